@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"ocr-server/internal/ocr"
+	"ocr-server/logger"
+	"os"
+	"text/tabwriter"
+)
+
+// subcommands 引擎管理子命令，均不读取标准输入
+var subcommands = map[string]func(args []string) int{
+	"install-engine":  installEngine,
+	"list-engines":    listEngines,
+	"remove-engine":   removeEngine,
+	"activate-engine": activateEngine,
+}
+
+// installEngine 安装引擎：指定 --from 时从本地压缩包或目录安装（用于离线部署），否则联网下载 --version 指定的版本
+func installEngine(args []string) int {
+	fs := flag.NewFlagSet("install-engine", flag.ExitOnError)
+	from := fs.String("from", "", "本地引擎压缩包（7z/tar.gz/tar.xz）或已解压的目录")
+	version := fs.String("version", "", "安装的版本号，--from 时默认从文件名推断")
+	proxy := fs.String("proxy", "", "联网下载时使用的代理")
+	sha256 := fs.String("sha256", "", "压缩包的 SHA-256，不一致时拒绝安装")
+	publicKey := fs.String("public-key", "", "base64 编码的 Ed25519 公钥，设置后必须校验签名")
+	signature := fs.String("signature", "", "分离签名文件，默认为压缩包路径加 .sig")
+	allowUnverified := fs.Bool("allow-unverified", false, "没有 SHA-256 和签名时仍然安装")
+	fs.Parse(args)
+
+	opts := ocr.InstallOptions{
+		Version:         *version,
+		Proxy:           *proxy,
+		SHA256:          *sha256,
+		PublicKey:       *publicKey,
+		Signature:       *signature,
+		AllowUnverified: *allowUnverified,
+	}
+	var exePath string
+	var err error
+	if *from != "" {
+		exePath, err = ocr.InstallFrom(*from, opts)
+	} else {
+		exePath, err = ocr.InstallVersion(opts)
+	}
+	if err != nil {
+		logger.LogError("安装 OCR 引擎失败: %v", err)
+		return 1
+	}
+	logger.LogInfo("OCR 引擎已安装: %s", exePath)
+	return 0
+}
+
+// listEngines 列出已安装的引擎版本，* 表示默认版本
+func listEngines(args []string) int {
+	m, err := ocr.ListEngines()
+	if err != nil {
+		logger.LogError("读取引擎清单失败: %v", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tVERSION\tINSTALLED\tPATH")
+	for _, e := range m.Engines {
+		mark := ""
+		if e.Version == m.Active {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", mark, e.Version, e.InstalledAt.Format("2006-01-02 15:04:05"), e.ExePath)
+	}
+	w.Flush()
+	return 0
+}
+
+// removeEngine 删除指定版本
+func removeEngine(args []string) int {
+	if len(args) != 1 {
+		logger.LogError("用法: remove-engine <版本>")
+		return 2
+	}
+	if err := ocr.RemoveEngine(args[0]); err != nil {
+		logger.LogError("删除 OCR 引擎失败: %v", err)
+		return 1
+	}
+	logger.LogInfo("OCR 引擎 %s 已删除", args[0])
+	return 0
+}
+
+// activateEngine 设置默认版本，未固定 engine_version 的服务会在新建处理器时使用该版本
+func activateEngine(args []string) int {
+	if len(args) != 1 {
+		logger.LogError("用法: activate-engine <版本>")
+		return 2
+	}
+	if err := ocr.ActivateEngine(args[0]); err != nil {
+		logger.LogError("激活 OCR 引擎失败: %v", err)
+		return 1
+	}
+	logger.LogInfo("OCR 引擎 %s 已设为默认版本", args[0])
+	return 0
+}
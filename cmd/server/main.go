@@ -14,24 +14,57 @@ var (
 	showVersion = flag.Bool("version", false, "显示版本信息")
 
 	// 新增命令行参数
-	addr             = flag.String("addr", "", "服务器地址")
-	port             = flag.Int("port", 0, "服务器端口")
-	ocrExePath       = flag.String("ocr-exe", "", "OCR可执行文件路径")
-	minProcessors    = flag.Int("min-processors", 0, "最小处理器数量")
-	maxProcessors    = flag.Int("max-processors", 0, "最大处理器数量")
-	queueSize        = flag.Int("queue-size", 0, "队列大小")
-	scaleThreshold   = flag.Int64("scale-threshold", 0, "扩展阈值")
-	degradeThreshold = flag.Int64("degrade-threshold", 0, "降级阈值")
-	idleTimeout      = flag.Duration("idle-timeout", 0, "空闲超时时间")
-	warmUpCount      = flag.Int("warm-up-count", 0, "预热数量")
-	shutdownTimeout  = flag.Duration("shutdown-timeout", 0, "关闭超时时间")
-	logFilePath      = flag.String("log-file", "", "日志文件路径")
-	logMaxSize       = flag.Int("log-max-size", 0, "最大日志文件大小（MB）")
-	logMaxBackups    = flag.Int("log-max-backups", 0, "最大日志文件备份数")
-	logMaxAge        = flag.Int("log-max-age", 0, "最大日志文件保留天数")
-	logCompress      = flag.Bool("log-compress", false, "是否压缩日志文件")
-	thresholdMode    = flag.Int("threshold-mode", 0, "二值化阈值模式 0 binary,1 otsu")
-	thresholdValue   = flag.Int("threshold-value", 100, "二值化阈值 0-255")
+	addr              = flag.String("addr", "", "服务器地址")
+	port              = flag.Int("port", 0, "服务器端口")
+	engine            = flag.String("engine", "", "OCR引擎后端名称")
+	ocrExePath        = flag.String("ocr-exe", "", "OCR可执行文件路径")
+	engineVersion     = flag.String("engine-version", "", "固定使用的引擎版本")
+	engineBundle      = flag.String("engine-bundle", "", "预置的引擎压缩包或目录")
+	offline           = flag.Bool("offline", false, "离线模式，禁止联网下载引擎")
+	allowUnverified   = flag.Bool("allow-unverified-engine", false, "允许安装没有 SHA-256 和签名可校验的引擎包")
+	lang              = flag.String("lang", "", "识别语言，如 chinese、en、japan")
+	configPath        = flag.String("config-path", "", "引擎语言配置文件，优先于 -lang")
+	modelsPath        = flag.String("models-path", "", "引擎模型根目录")
+	detModelDir       = flag.String("det-model-dir", "", "检测模型目录")
+	clsModelDir       = flag.String("cls-model-dir", "", "方向分类模型目录")
+	recModelDir       = flag.String("rec-model-dir", "", "识别模型目录")
+	useAngleCls       = flag.Bool("use-angle-cls", false, "启用方向分类器")
+	limitSideLen      = flag.Int("limit-side-len", 0, "检测时图片长边的上限")
+	recBatchNum       = flag.Int("rec-batch-num", 0, "识别的批大小")
+	cpuThreads        = flag.Int("cpu-threads", 0, "每个引擎进程的 CPU 推理线程数")
+	minProcessors     = flag.Int("min-processors", 0, "最小处理器数量")
+	maxProcessors     = flag.Int("max-processors", 0, "最大处理器数量")
+	maxEngines        = flag.Int("max-engines", 0, "所有处理器池的引擎实例总数上限")
+	queueSize         = flag.Int("queue-size", 0, "队列大小")
+	maxUploadMB       = flag.Int("max-upload-mb", 0, "识别请求体的大小上限（MB）")
+	maxBatchSize      = flag.Int("max-batch-size", 0, "批量识别请求的最大图片数")
+	admissionPolicy   = flag.String("admission-policy", "", "队列已满时的准入策略：reject、wait、shed")
+	admissionWait     = flag.Duration("admission-wait", 0, "wait 策略下等待队列空位的最长时间")
+	scaleThreshold    = flag.Int64("scale-threshold", 0, "扩展阈值")
+	degradeThreshold  = flag.Int64("degrade-threshold", 0, "降级阈值")
+	idleTimeout       = flag.Duration("idle-timeout", 0, "空闲超时时间")
+	scaleInterval     = flag.Duration("scale-interval", 0, "自动伸缩检查周期")
+	scaleWaitTarget   = flag.Duration("scale-wait-target", 0, "等待时间 P95 超过该值时扩容")
+	scaleUpCooldown   = flag.Duration("scale-up-cooldown", 0, "两次扩容的最小间隔")
+	scaleDownCooldown = flag.Duration("scale-down-cooldown", 0, "两次缩容的最小间隔")
+	warmUpCount       = flag.Int("warm-up-count", 0, "预热数量")
+	shutdownTimeout   = flag.Duration("shutdown-timeout", 0, "关闭超时时间")
+	recycleAfterJobs  = flag.Int64("recycle-after-jobs", 0, "引擎处理多少个任务后回收")
+	recycleAfterAge   = flag.Duration("recycle-after-age", 0, "引擎运行多久后回收")
+	recycleRSSMB      = flag.Int("recycle-rss-mb", 0, "引擎常驻内存超过多少 MB 后回收")
+	acquireTimeout    = flag.Duration("acquire-timeout", 0, "等待可用处理器的超时时间")
+	taskTimeout       = flag.Duration("task-timeout", 0, "单个任务的超时时间")
+	jobTTL            = flag.Duration("job-ttl", 0, "异步任务结果的保留时间")
+	maxJobs           = flag.Int("max-jobs", 0, "同时保存的异步任务数上限")
+	breakerRate       = flag.Int("breaker-failure-rate", 0, "引擎失败率（%）达到该值时熔断")
+	breakerTimeout    = flag.Duration("breaker-open-timeout", 0, "熔断多久后放行探测任务")
+	logFilePath       = flag.String("log-file", "", "日志文件路径")
+	logMaxSize        = flag.Int("log-max-size", 0, "最大日志文件大小（MB）")
+	logMaxBackups     = flag.Int("log-max-backups", 0, "最大日志文件备份数")
+	logMaxAge         = flag.Int("log-max-age", 0, "最大日志文件保留天数")
+	logCompress       = flag.Bool("log-compress", false, "是否压缩日志文件")
+	thresholdMode     = flag.Int("threshold-mode", 0, "二值化阈值模式 0 binary,1 otsu")
+	thresholdValue    = flag.Int("threshold-value", 100, "二值化阈值 0-255")
 )
 
 func main() {
@@ -41,6 +74,11 @@ func main() {
 			os.Exit(1)
 		}
 	}()
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
 	flag.Parse()
 
 	if *showVersion {
@@ -91,18 +129,78 @@ func applyCommandLineArgs(cfg *config.Config) {
 	if *port != 0 {
 		cfg.Port = *port
 	}
+	if *engine != "" {
+		cfg.Engine = *engine
+	}
 	if *ocrExePath != "" {
 		cfg.OCRExePath = *ocrExePath
 	}
+	if *engineVersion != "" {
+		cfg.Version = *engineVersion
+	}
+	if *engineBundle != "" {
+		cfg.Bundle = *engineBundle
+	}
+	if *offline {
+		cfg.Offline = true
+	}
+	if *allowUnverified {
+		cfg.AllowUnverified = true
+	}
+	if *lang != "" {
+		cfg.Paddle.Lang = *lang
+	}
+	if *configPath != "" {
+		cfg.Paddle.ConfigPath = *configPath
+	}
+	if *modelsPath != "" {
+		cfg.Paddle.ModelsPath = *modelsPath
+	}
+	if *detModelDir != "" {
+		cfg.Paddle.DetModelDir = *detModelDir
+	}
+	if *clsModelDir != "" {
+		cfg.Paddle.ClsModelDir = *clsModelDir
+	}
+	if *recModelDir != "" {
+		cfg.Paddle.RecModelDir = *recModelDir
+	}
+	if *useAngleCls {
+		cfg.Paddle.UseAngleCls = true
+	}
+	if *limitSideLen != 0 {
+		cfg.Paddle.LimitSideLen = *limitSideLen
+	}
+	if *recBatchNum != 0 {
+		cfg.Paddle.RecBatchNum = *recBatchNum
+	}
+	if *cpuThreads != 0 {
+		cfg.Paddle.CPUThreads = *cpuThreads
+	}
 	if *minProcessors != 0 {
 		cfg.MinProcessors = *minProcessors
 	}
 	if *maxProcessors != 0 {
 		cfg.MaxProcessors = *maxProcessors
 	}
+	if *maxEngines != 0 {
+		cfg.MaxEngines = *maxEngines
+	}
 	if *queueSize != 0 {
 		cfg.QueueSize = *queueSize
 	}
+	if *maxUploadMB != 0 {
+		cfg.MaxUploadMB = *maxUploadMB
+	}
+	if *maxBatchSize != 0 {
+		cfg.MaxBatchSize = *maxBatchSize
+	}
+	if *admissionPolicy != "" {
+		cfg.AdmissionPolicy = *admissionPolicy
+	}
+	if *admissionWait != 0 {
+		cfg.AdmissionWait = *admissionWait
+	}
 	if *scaleThreshold != 0 {
 		cfg.ScaleThreshold = *scaleThreshold
 	}
@@ -112,12 +210,51 @@ func applyCommandLineArgs(cfg *config.Config) {
 	if *idleTimeout != 0 {
 		cfg.IdleTimeout = *idleTimeout
 	}
+	if *scaleInterval != 0 {
+		cfg.ScaleInterval = *scaleInterval
+	}
+	if *scaleWaitTarget != 0 {
+		cfg.ScaleWaitTarget = *scaleWaitTarget
+	}
+	if *scaleUpCooldown != 0 {
+		cfg.ScaleUpCooldown = *scaleUpCooldown
+	}
+	if *scaleDownCooldown != 0 {
+		cfg.ScaleDownCooldown = *scaleDownCooldown
+	}
 	if *warmUpCount != 0 {
 		cfg.WarmUpCount = *warmUpCount
 	}
+	if *recycleAfterJobs != 0 {
+		cfg.RecycleAfterJobs = *recycleAfterJobs
+	}
+	if *recycleAfterAge != 0 {
+		cfg.RecycleAfterAge = *recycleAfterAge
+	}
+	if *recycleRSSMB != 0 {
+		cfg.RecycleRSSMB = *recycleRSSMB
+	}
 	if *shutdownTimeout != 0 {
 		cfg.ShutdownTimeout = *shutdownTimeout
 	}
+	if *acquireTimeout != 0 {
+		cfg.AcquireTimeout = *acquireTimeout
+	}
+	if *taskTimeout != 0 {
+		cfg.TaskTimeout = *taskTimeout
+	}
+	if *jobTTL != 0 {
+		cfg.JobTTL = *jobTTL
+	}
+	if *maxJobs != 0 {
+		cfg.MaxJobs = *maxJobs
+	}
+	if *breakerRate != 0 {
+		cfg.BreakerFailureRate = *breakerRate
+	}
+	if *breakerTimeout != 0 {
+		cfg.BreakerOpenTimeout = *breakerTimeout
+	}
 	if *logFilePath != "" {
 		cfg.LogFilePath = *logFilePath
 	}
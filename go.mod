@@ -9,6 +9,7 @@ require (
 	github.com/doraemonkeys/paddleocr v1.0.4
 	github.com/go-playground/validator/v10 v10.22.0
 	github.com/spf13/viper v1.19.0
+	github.com/ulikunitz/xz v0.5.12
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -38,7 +39,6 @@ require (
 	github.com/spf13/cast v1.6.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/subosito/gotenv v1.6.0 // indirect
-	github.com/ulikunitz/xz v0.5.12 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
 	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
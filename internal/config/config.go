@@ -5,6 +5,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"ocr-server/internal/ocr"
 	"ocr-server/logger"
+	"ocr-server/pkg/ocrengine"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -15,24 +16,95 @@ import (
 )
 
 type Config struct {
-	Addr             string        `mapstructure:"addr" yaml:"addr" validate:"required"`                                     // 服务器地址
-	Port             int           `mapstructure:"port" yaml:"port" validate:"required,min=1,max=65535"`                     // 服务器端口
-	OCRExePath       string        `mapstructure:"ocr_exe_path" yaml:"ocr_exe_path"`                                         // OCR 可执行文件路径
-	MinProcessors    int           `mapstructure:"min_processors" yaml:"min_processors" validate:"required,min=2"`           // 最小处理器数量
-	MaxProcessors    int           `mapstructure:"max_processors" yaml:"max_processors" validate:"required,min=1"`           // 最大处理器数量
-	QueueSize        int           `mapstructure:"queue_size" yaml:"queue_size" validate:"required,min=1"`                   // 任务队列大小
-	ScaleThreshold   int64         `mapstructure:"scale_threshold" yaml:"scale_threshold" validate:"required,min=0"`         // 扩展处理器阈值
-	DegradeThreshold int64         `mapstructure:"degrade_threshold" yaml:"degrade_threshold" validate:"required,min=0"`     // 缩减处理器阈值
-	IdleTimeout      time.Duration `mapstructure:"idle_timeout" yaml:"idle_timeout" validate:"required"`                     // 处理器空闲超时时间
-	WarmUpCount      int           `mapstructure:"warm_up_count" yaml:"warm_up_count" validate:"required,min=0"`             // 预热处理器数量
-	ShutdownTimeout  time.Duration `mapstructure:"shutdown_timeout" yaml:"shutdown_timeout" validate:"required"`             // 优雅关闭超时时间
-	LogFilePath      string        `mapstructure:"log_file_path" yaml:"log_file_path" validate:"required"`                   // 日志文件路径名
-	LogMaxSize       int           `mapstructure:"log_max_size" yaml:"log_max_size" validate:"required,min=10"`              // 日志文件最大大小（MB）
-	LogMaxBackups    int           `mapstructure:"log_max_backups" yaml:"log_max_backups" validate:"required,min=0"`         // 保留的旧日志文件最大数量
-	LogMaxAge        int           `mapstructure:"log_max_age" yaml:"log_max_age" validate:"required,min=1"`                 // 保留旧日志文件的最大天数
-	LogCompress      bool          `mapstructure:"log_compress" yaml:"log_compress"`                                         // 是否压缩轮转的日志文件
-	ThresholdMode    int           `mapstructure:"threshold_mode" yaml:"threshold_mode"`                                     // 阈值模式
-	ThresholdValue   int           `mapstructure:"threshold_value" yaml:"threshold_value" validate:"required,min=0,max=255"` // 阈值
+	Addr               string        `mapstructure:"addr" yaml:"addr" validate:"required"`                                         // 服务器地址
+	Port               int           `mapstructure:"port" yaml:"port" validate:"required,min=1,max=65535"`                         // 服务器端口
+	Engine             string        `mapstructure:"engine" yaml:"engine"`                                                         // OCR 引擎后端名称
+	OCRExePath         string        `mapstructure:"ocr_exe_path" yaml:"ocr_exe_path"`                                             // OCR 可执行文件路径，为空时自动查找或安装
+	MinProcessors      int           `mapstructure:"min_processors" yaml:"min_processors" validate:"required,min=2"`               // 最小处理器数量
+	MaxProcessors      int           `mapstructure:"max_processors" yaml:"max_processors" validate:"required,min=1"`               // 最大处理器数量
+	QueueSize          int           `mapstructure:"queue_size" yaml:"queue_size" validate:"required,min=1"`                       // 任务队列大小
+	Lanes              []LaneConfig  `mapstructure:"lanes" yaml:"lanes" validate:"dive"`                                           // 优先级队列，为空时使用 high、normal、low 三条队列
+	DefaultPriority    string        `mapstructure:"default_priority" yaml:"default_priority"`                                     // 请求未指定 priority 时使用的队列
+	MaxUploadMB        int           `mapstructure:"max_upload_mb" yaml:"max_upload_mb" validate:"min=0"`                          // 识别请求体的大小上限（MB），0 表示不限制
+	MaxBatchSize       int           `mapstructure:"max_batch_size" yaml:"max_batch_size" validate:"min=0"`                        // 批量识别请求的最大图片数，0 表示不限制
+	MaxInFlight        int           `mapstructure:"max_in_flight" yaml:"max_in_flight" validate:"min=0"`                          // 同时从队列取出执行的任务数，0 表示只受各处理器池的 max_processors 限制
+	ScaleThreshold     int64         `mapstructure:"scale_threshold" yaml:"scale_threshold" validate:"required,min=0,max=100"`     // 扩展处理器阈值：利用率（%）达到该值时扩容
+	DegradeThreshold   int64         `mapstructure:"degrade_threshold" yaml:"degrade_threshold" validate:"required,min=0,max=100"` // 缩减处理器阈值：利用率（%）不高于该值时缩容
+	ScaleInterval      time.Duration `mapstructure:"scale_interval" yaml:"scale_interval" validate:"min=0"`                        // 自动伸缩的检查周期，0 表示关闭自动伸缩
+	ScaleWaitTarget    time.Duration `mapstructure:"scale_wait_target" yaml:"scale_wait_target" validate:"min=0"`                  // 等待处理器时间的 P95 超过该值时扩容，0 表示不按等待时间扩容
+	ScaleUpCooldown    time.Duration `mapstructure:"scale_up_cooldown" yaml:"scale_up_cooldown" validate:"min=0"`                  // 两次扩容的最小间隔
+	ScaleDownCooldown  time.Duration `mapstructure:"scale_down_cooldown" yaml:"scale_down_cooldown" validate:"min=0"`              // 两次缩容、以及扩容后到缩容的最小间隔
+	IdleTimeout        time.Duration `mapstructure:"idle_timeout" yaml:"idle_timeout" validate:"required"`                         // 处理器空闲超时时间
+	WarmUpCount        int           `mapstructure:"warm_up_count" yaml:"warm_up_count" validate:"required,min=0"`                 // 预热处理器数量
+	RecycleAfterJobs   int64         `mapstructure:"recycle_after_jobs" yaml:"recycle_after_jobs" validate:"min=0"`                // 引擎处理多少个任务后回收替换，0 表示不限制
+	RecycleAfterAge    time.Duration `mapstructure:"recycle_after_age" yaml:"recycle_after_age" validate:"min=0"`                  // 引擎运行多久后回收替换，0 表示不限制
+	RecycleRSSMB       int           `mapstructure:"recycle_rss_mb" yaml:"recycle_rss_mb" validate:"min=0"`                        // 引擎进程常驻内存超过多少 MB 后回收替换（读取 /proc），0 表示不限制
+	ShutdownTimeout    time.Duration `mapstructure:"shutdown_timeout" yaml:"shutdown_timeout" validate:"required"`                 // 优雅关闭超时时间
+	HealthProbeImage   string        `mapstructure:"health_probe_image" yaml:"health_probe_image"`                                 // 健康检查使用的样例图像，为空时使用内置图像
+	HealthProbeText    string        `mapstructure:"health_probe_text" yaml:"health_probe_text"`                                   // 样例图像应识别出的文本，为空时只要求识别成功
+	HealthProbeTimeout time.Duration `mapstructure:"health_probe_timeout" yaml:"health_probe_timeout" validate:"min=0"`            // 单个处理器健康检查的超时时间
+	AcquireTimeout     time.Duration `mapstructure:"acquire_timeout" yaml:"acquire_timeout" validate:"min=0"`                      // 任务等待可用处理器的超时时间，0 表示不限制
+	TaskTimeout        time.Duration `mapstructure:"task_timeout" yaml:"task_timeout" validate:"min=0"`                            // 单个任务从出队到完成的超时时间（含等待和重试），0 表示不限制
+	JobTTL             time.Duration `mapstructure:"job_ttl" yaml:"job_ttl" validate:"min=0"`                                      // 异步任务完成后保留结果的时间，0 表示使用默认的 1 小时
+	MaxJobs            int           `mapstructure:"max_jobs" yaml:"max_jobs" validate:"min=0"`                                    // 同时保存的异步任务数上限（含未完成和保留中的），0 表示使用默认的 10000
+	LogFilePath        string        `mapstructure:"log_file_path" yaml:"log_file_path" validate:"required"`                       // 日志文件路径名
+	LogMaxSize         int           `mapstructure:"log_max_size" yaml:"log_max_size" validate:"required,min=10"`                  // 日志文件最大大小（MB）
+	LogMaxBackups      int           `mapstructure:"log_max_backups" yaml:"log_max_backups" validate:"required,min=0"`             // 保留的旧日志文件最大数量
+	LogMaxAge          int           `mapstructure:"log_max_age" yaml:"log_max_age" validate:"required,min=1"`                     // 保留旧日志文件的最大天数
+	LogCompress        bool          `mapstructure:"log_compress" yaml:"log_compress"`                                             // 是否压缩轮转的日志文件
+	ThresholdMode      int           `mapstructure:"threshold_mode" yaml:"threshold_mode"`                                         // 阈值模式
+	ThresholdValue     int           `mapstructure:"threshold_value" yaml:"threshold_value" validate:"required,min=0,max=255"`     // 阈值
+
+	ocr.InstallOptions `mapstructure:",squash" yaml:",inline"` // 引擎安装参数：engine_bundle、offline、download_proxy
+
+	Paddle     ocrengine.PaddleArgs       `mapstructure:"paddle" yaml:"paddle"`                 // paddleocr 引擎启动参数：模型目录、语言、方向分类器等
+	Models     map[string]ModelConfig     `mapstructure:"models" yaml:"models" validate:"dive"` // 命名的模型，请求通过 model 选择，每个模型有独立的处理器池
+	FakeEngine ocrengine.FakeOptions      `mapstructure:"fake_engine" yaml:"fake_engine"`       // fake 引擎参数，engine 为 fake 时生效
+	Tesseract  ocrengine.TesseractOptions `mapstructure:"tesseract" yaml:"tesseract"`           // tesseract 引擎参数
+
+	AllowedLangs []string `mapstructure:"allowed_langs" yaml:"allowed_langs"`              // 请求可以通过 lang 选择的语言，每种语言有独立的处理器池；为空时 lang 只能选择 models 中的模型
+	MaxEngines   int      `mapstructure:"max_engines" yaml:"max_engines" validate:"min=0"` // 所有处理器池的引擎实例总数上限，0 表示不限制
+
+	ClientHeader    string         `mapstructure:"client_header" yaml:"client_header"`                                                   // 未使用 API Key 时标识客户端的请求头
+	RequireAPIKey   bool           `mapstructure:"require_api_key" yaml:"require_api_key"`                                               // 只接受携带 clients 中配置的 API Key 的请求
+	Clients         []ClientConfig `mapstructure:"clients" yaml:"clients" validate:"dive"`                                               // 已知客户端及其限制
+	AdmissionPolicy string         `mapstructure:"admission_policy" yaml:"admission_policy" validate:"omitempty,oneof=reject wait shed"` // 队列已满时的准入策略
+	AdmissionWait   time.Duration  `mapstructure:"admission_wait" yaml:"admission_wait" validate:"min=0"`                                // wait 策略下等待队列空位的最长时间
+	DefaultClient   ClientLimits   `mapstructure:"default_client" yaml:"default_client"`                                                 // 未在 clients 中配置的客户端使用的限制
+
+	BreakerFailureRate int           `mapstructure:"breaker_failure_rate" yaml:"breaker_failure_rate" validate:"min=0,max=100"` // 引擎失败率（%）达到该值时熔断，0 表示不启用
+	BreakerMinRequests int           `mapstructure:"breaker_min_requests" yaml:"breaker_min_requests" validate:"min=0"`         // 统计窗口内至少有多少次调用才判断失败率
+	BreakerWindow      int           `mapstructure:"breaker_window" yaml:"breaker_window" validate:"min=0"`                     // 统计最近多少次引擎调用
+	BreakerOpenTimeout time.Duration `mapstructure:"breaker_open_timeout" yaml:"breaker_open_timeout" validate:"min=0"`         // 熔断多久后放行探测任务
+}
+
+// LaneConfig 一条优先级队列，多条队列有任务时按权重分配调度机会
+type LaneConfig struct {
+	Name      string `mapstructure:"name" yaml:"name" validate:"required"`
+	Weight    int    `mapstructure:"weight" yaml:"weight" validate:"min=1"`
+	QueueSize int    `mapstructure:"queue_size" yaml:"queue_size" validate:"min=1"`
+}
+
+// ClientLimits 客户端的调度权重、并发上限和每日配额，0 表示不限制
+type ClientLimits struct {
+	Weight        int   `mapstructure:"weight" yaml:"weight" validate:"min=0"`                 // 同一队列中轮到该客户端时连续调度的任务数，默认 1
+	MaxConcurrent int   `mapstructure:"max_concurrent" yaml:"max_concurrent" validate:"min=0"` // 同时执行的任务数上限
+	DailyQuota    int64 `mapstructure:"daily_quota" yaml:"daily_quota" validate:"min=0"`       // 每天接受的请求数上限
+}
+
+// ClientConfig 通过 API Key 识别的客户端
+type ClientConfig struct {
+	Name         string `mapstructure:"name" yaml:"name" validate:"required"`
+	APIKey       string `mapstructure:"api_key" yaml:"api_key"` // 通过 X-API-Key 或 Authorization: Bearer 传递
+	ClientLimits `mapstructure:",squash" yaml:",inline"`
+}
+
+// ModelConfig 命名的 paddleocr 模型，未设置的启动参数沿用 paddle 中的配置
+type ModelConfig struct {
+	ocrengine.PaddleArgs `mapstructure:",squash" yaml:",inline"`
+
+	MinProcessors int `mapstructure:"min_processors" yaml:"min_processors" validate:"min=0"` // 该模型的最小处理器数量，首次使用时创建
+	MaxProcessors int `mapstructure:"max_processors" yaml:"max_processors" validate:"min=0"` // 该模型的最大处理器数量，0 表示与 max_processors 相同
 }
 
 func LoadConfig() (Config, error) {
@@ -50,11 +122,32 @@ func LoadConfig() (Config, error) {
 }
 
 func setDefaults(cfg *Config) {
-	cfg.OCRExePath = ocr.GetOCREnginePath()
+	cfg.Engine = ocrengine.DefaultEngine
 	cfg.MaxProcessors = runtime.NumCPU()
+	cfg.MaxEngines = 2 * runtime.NumCPU()
 	cfg.ScaleThreshold = 75
 	cfg.DegradeThreshold = 25
+	cfg.DefaultPriority = "normal"
+	cfg.ClientHeader = "X-Client-ID"
+	cfg.MaxUploadMB = 20
+	cfg.MaxBatchSize = 100
+	cfg.AdmissionPolicy = "wait"
+	cfg.AdmissionWait = 10 * time.Second
+	cfg.BreakerFailureRate = 50
+	cfg.BreakerMinRequests = 10
+	cfg.BreakerWindow = 20
+	cfg.BreakerOpenTimeout = 30 * time.Second
+	cfg.ScaleInterval = 5 * time.Second
+	cfg.ScaleWaitTarget = 500 * time.Millisecond
+	cfg.ScaleUpCooldown = 10 * time.Second
+	cfg.ScaleDownCooldown = time.Minute
 	cfg.ShutdownTimeout = 30 * time.Second
+	cfg.HealthProbeTimeout = 30 * time.Second
+	cfg.AcquireTimeout = 30 * time.Second
+	cfg.RecycleAfterAge = 20 * time.Minute // 与旧版每 20 分钟重启引擎一致
+	cfg.TaskTimeout = 2 * time.Minute
+	cfg.JobTTL = time.Hour
+	cfg.MaxJobs = 10000
 	cfg.LogMaxBackups = 3
 	cfg.LogMaxAge = 28
 	cfg.ThresholdMode = 0
@@ -0,0 +1,116 @@
+package ocr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const engineVersion = "v1.4.1"
+
+// engineArtifact 某个平台对应的 PaddleOCR-json 发布包
+type engineArtifact struct {
+	URL     string // 下载地址
+	ExeName string // 解压后可执行文件相对 resDir 的路径
+	SHA256  string // 发布包的固定 SHA-256，为空时必须通过 engine_sha256 或签名校验，或设置 allow_unverified_engine
+}
+
+// FileName 下载到本地的压缩包文件名，保留原始扩展名以便选择解压方式
+func (a engineArtifact) FileName() string {
+	return filepath.Base(a.URL)
+}
+
+var engineArtifacts = map[string]engineArtifact{
+	"windows/amd64": {
+		URL:     "https://github.com/hiroi-sora/PaddleOCR-json/releases/download/" + engineVersion + "/PaddleOCR-json_" + engineVersion + "_windows_x64.7z",
+		ExeName: "PaddleOCR-json_" + engineVersion + "/PaddleOCR-json.exe",
+	},
+	"linux/amd64": {
+		URL:     "https://github.com/hiroi-sora/PaddleOCR-json/releases/download/" + engineVersion + "/PaddleOCR-json_" + engineVersion + "_debian_gcc_x86-64.tar.xz",
+		ExeName: "PaddleOCR-json_" + engineVersion + "_debian_gcc_x86-64/bin/PaddleOCR-json",
+	},
+}
+
+// resolveArtifact 按当前 GOOS/GOARCH 选择指定版本的发布包，
+// 非内置版本按相同的命名规则生成下载地址，且没有固定的 SHA-256，下载后需要 engine_sha256 或签名才能安装
+func resolveArtifact(version string) (engineArtifact, error) {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	artifact, ok := engineArtifacts[platform]
+	if !ok {
+		return engineArtifact{}, fmt.Errorf("PaddleOCR-json 没有适用于 %s 的发布包", platform)
+	}
+	if version != "" && version != engineVersion {
+		artifact.URL = strings.ReplaceAll(artifact.URL, engineVersion, version)
+		artifact.ExeName = strings.ReplaceAll(artifact.ExeName, engineVersion, version)
+		artifact.SHA256 = ""
+	}
+	return artifact, nil
+}
+
+// archiveExt 返回支持的压缩包扩展名
+func archiveExt(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.xz", ".7z"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return ext
+		}
+	}
+	return filepath.Ext(name)
+}
+
+var libraryPathOnce sync.Map
+
+// PrepareEngineEnv 为引擎可执行文件设置运行环境：
+// 非 Windows 平台补充可执行权限，并把动态库目录加入 LD_LIBRARY_PATH（子进程会继承当前进程的环境变量）
+func PrepareEngineEnv(exePath string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if err := ensureExecutable(exePath); err != nil {
+		return err
+	}
+	for _, dir := range libraryDirs(exePath) {
+		if _, loaded := libraryPathOnce.LoadOrStore(dir, struct{}{}); loaded {
+			continue
+		}
+		libPath := dir
+		if old := os.Getenv("LD_LIBRARY_PATH"); old != "" {
+			libPath = dir + string(os.PathListSeparator) + old
+		}
+		if err := os.Setenv("LD_LIBRARY_PATH", libPath); err != nil {
+			return fmt.Errorf("设置 LD_LIBRARY_PATH 失败: %w", err)
+		}
+	}
+	return nil
+}
+
+func ensureExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("获取引擎文件信息失败: %w", err)
+	}
+	if info.Mode()&0111 == 0111 {
+		return nil
+	}
+	if err := os.Chmod(path, info.Mode()|0755); err != nil {
+		return fmt.Errorf("设置引擎可执行权限失败: %w", err)
+	}
+	return nil
+}
+
+// libraryDirs 查找可执行文件附近的动态库目录
+func libraryDirs(exePath string) []string {
+	exeDir, err := filepath.Abs(filepath.Dir(exePath))
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, dir := range []string{filepath.Join(exeDir, "lib"), filepath.Join(filepath.Dir(exeDir), "lib")} {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,329 @@
+package ocr
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"ocr-server/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/ulikunitz/xz"
+)
+
+// 解压限制，测试中会调小
+var (
+	maxArchiveEntries       = 20000   // 压缩包最多允许的条目数
+	maxExtractedSize  int64 = 4 << 30 // 解压后的总大小上限（字节）
+)
+
+var (
+	// ErrUnsafePath 条目路径为绝对路径或试图跳出解压目录
+	ErrUnsafePath = errors.New("压缩包条目路径不安全")
+	// ErrArchiveTooLarge 条目数或解压后大小超过限制
+	ErrArchiveTooLarge = errors.New("压缩包超出大小限制")
+)
+
+// extractArchive 将引擎压缩包解压为 dest 目录：先解压到 resDir 下的临时目录，全部成功后再整体替换 dest，
+// 任何一个条目失败都会返回错误并丢弃临时目录，不会留下半解压的引擎
+func extractArchive(archivePath, dest string) error {
+	if err := os.MkdirAll(resDir, 0755); err != nil {
+		return fmt.Errorf("创建 res 目录失败: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(resDir, ".extract-")
+	if err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	switch archiveExt(archivePath) {
+	case ".7z":
+		err = unZip(archivePath, tmpDir)
+	case ".tar.gz", ".tgz", ".tar.xz":
+		err = unTar(archivePath, tmpDir)
+	default:
+		return fmt.Errorf("不支持的压缩包格式: %s", archivePath)
+	}
+	if err != nil {
+		logger.LogError("提取失败: %v", err)
+		return err
+	}
+
+	if err := commitDir(tmpDir, dest); err != nil {
+		return err
+	}
+	logger.LogInfo("提取成功完成。")
+	return nil
+}
+
+// commitDir 将临时目录重命名为 dest，已存在的 dest 先备份，失败时还原
+func commitDir(tmpDir, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+	backup := ""
+	if _, err := os.Lstat(dest); err == nil {
+		backup = fmt.Sprintf("%s.old-%d", dest, time.Now().UnixNano())
+		if err := os.Rename(dest, backup); err != nil {
+			return fmt.Errorf("备份 %s 失败: %w", dest, err)
+		}
+	}
+	if err := os.Rename(tmpDir, dest); err != nil {
+		if backup != "" {
+			os.Rename(backup, dest)
+		}
+		return fmt.Errorf("替换 %s 失败: %w", dest, err)
+	}
+	if backup != "" {
+		if err := os.RemoveAll(backup); err != nil {
+			logger.LogWarning("删除旧文件 %s 失败: %v", backup, err)
+		}
+	}
+	return nil
+}
+
+// safeJoin 将压缩包中的条目名拼接到 root 下，拒绝绝对路径和包含 .. 跳出 root 的路径
+func safeJoin(root, name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if !withinDir(root, path) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return path, nil
+}
+
+func withinDir(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkParents 确认 root 与 path 之间已存在的各级目录都不是符号链接。
+// 之前解压出的符号链接可能指向 root 之外，经过它写入的条目即使路径在字面上位于 root 内也会写到外面
+func checkParents(root, path string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	dir := root
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, elem)
+		info, err := os.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s 位于符号链接 %s 之下", ErrUnsafePath, path, dir)
+		}
+	}
+	return nil
+}
+
+// checkNotSymlink 拒绝写入已存在的符号链接，写文件会跟随链接写到链接目标
+func checkNotSymlink(path string) error {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %s 已是符号链接", ErrUnsafePath, path)
+	}
+	return nil
+}
+
+// checkLinkTarget 逐级解析 path 处符号链接的目标 target，每一步都不能离开 root。
+// .. 只允许从已存在的真实目录返回上级：从符号链接（或之后可能被创建为符号链接的路径）返回上级时，
+// 实际到达的是链接目标的上级，字面上的检查不再可靠
+func checkLinkTarget(root, path, target string) error {
+	unsafe := fmt.Errorf("%w: %s -> %s", ErrUnsafePath, path, target)
+	if target == "" || filepath.IsAbs(target) || filepath.VolumeName(target) != "" || strings.HasPrefix(target, "/") {
+		return unsafe
+	}
+	dir := filepath.Dir(path)
+	for _, elem := range strings.Split(filepath.ToSlash(target), "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+				return unsafe
+			}
+			dir = filepath.Dir(dir)
+		default:
+			dir = filepath.Join(dir, elem)
+		}
+		if !withinDir(root, dir) {
+			return unsafe
+		}
+	}
+	return nil
+}
+
+// extractLimiter 统计条目数和写入的字节数
+type extractLimiter struct {
+	entries int
+	written int64
+}
+
+func (l *extractLimiter) addEntry() error {
+	l.entries++
+	if l.entries > maxArchiveEntries {
+		return fmt.Errorf("%w: 条目数超过 %d", ErrArchiveTooLarge, maxArchiveEntries)
+	}
+	return nil
+}
+
+// writeFile 写入单个文件，超出总大小限制时返回错误
+func (l *extractLimiter) writeFile(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if perm == 0 {
+		perm = 0644
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm|0600)
+	if err != nil {
+		return err
+	}
+	remaining := maxExtractedSize - l.written
+	n, err := io.Copy(f, io.LimitReader(r, remaining+1))
+	l.written += n
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n > remaining {
+		return fmt.Errorf("%w: 解压后超过 %d 字节", ErrArchiveTooLarge, maxExtractedSize)
+	}
+	return nil
+}
+
+func unZip(archive, dest string) error {
+	r, err := sevenzip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("打开压缩文件失败: %w", err)
+	}
+	defer r.Close()
+
+	var limiter extractLimiter
+	for _, file := range r.File {
+		if err := limiter.addEntry(); err != nil {
+			return err
+		}
+		path, err := safeJoin(dest, file.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkParents(dest, path); err != nil {
+			return err
+		}
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := checkNotSymlink(path); err != nil {
+			return err
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = limiter.writeFile(path, rc, file.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("解压 %s 失败: %w", file.Name, err)
+		}
+	}
+	return nil
+}
+
+// unTar 解压 tar.gz / tar.xz 压缩包，保留文件权限和符号链接（Linux 动态库依赖符号链接）
+func unTar(archive, dest string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("打开压缩文件失败: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader
+	if archiveExt(archive) == ".tar.xz" {
+		r, err = xz.NewReader(f)
+	} else {
+		r, err = gzip.NewReader(f)
+	}
+	if err != nil {
+		return fmt.Errorf("读取压缩文件失败: %w", err)
+	}
+
+	var limiter extractLimiter
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取 tar 条目失败: %w", err)
+		}
+		if err := limiter.addEntry(); err != nil {
+			return err
+		}
+		path, err := safeJoin(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkParents(dest, path); err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := checkNotSymlink(path); err != nil {
+				return err
+			}
+			if err := limiter.writeFile(path, tr, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return fmt.Errorf("解压 %s 失败: %w", hdr.Name, err)
+			}
+		case tar.TypeSymlink:
+			// 符号链接的目标同样不能指向解压目录之外；已存在的目录不替换为符号链接，
+			// 否则之前按真实目录检查过的链接目标会改为经过该链接解析
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := checkLinkTarget(dest, path, hdr.Linkname); err != nil {
+				return err
+			}
+			if info, err := os.Lstat(path); err == nil {
+				if info.IsDir() {
+					return fmt.Errorf("%w: %s 已是目录", ErrUnsafePath, hdr.Name)
+				}
+				os.Remove(path)
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			logger.LogWarning("跳过不支持的 tar 条目类型: %s", hdr.Name)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package ocr
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry 构造 tar 包的一个条目，link 不为空时为符号链接，dir 为 true 时为目录
+type tarEntry struct {
+	name string
+	body string
+	link string
+	dir  bool
+}
+
+// writeTarGz 在 dir 下生成包含 entries 的 tar.gz
+func writeTarGz(t *testing.T, dir string, entries []tarEntry) string {
+	t.Helper()
+	path := filepath.Join(dir, "engine.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// extractDirs 返回 <tmp>/work/dest 作为解压目录，以及用来检查是否有文件写到解压目录之外的 <tmp>
+func extractDirs(t *testing.T) (dest, outside string) {
+	t.Helper()
+	outside = t.TempDir()
+	dest = filepath.Join(outside, "work", "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dest, outside
+}
+
+// checkNoEscape 确认 outside 中除了 work 目录之外没有新的文件
+func checkNoEscape(t *testing.T, outside string) {
+	t.Helper()
+	for _, dir := range []string{outside, filepath.Join(outside, "work")} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Name() != "work" && e.Name() != "dest" {
+				t.Errorf("解压目录之外出现了 %s", filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+}
+
+func TestUnTar(t *testing.T) {
+	dest, outside := extractDirs(t)
+	archive := writeTarGz(t, t.TempDir(), []tarEntry{
+		{name: "lib/", dir: true},
+		{name: "lib/libocr.so.1", body: "elf"},
+		{name: "lib/libocr.so", link: "libocr.so.1"},
+		{name: "bin/PaddleOCR-json", body: "bin"},
+		{name: "bin/libocr.so", link: "../lib/libocr.so"},
+		{name: "models/./config.txt", body: "cfg"},
+	})
+	if err := unTar(archive, dest); err != nil {
+		t.Fatalf("解压失败: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "bin", "libocr.so"))
+	if err != nil || string(data) != "elf" {
+		t.Fatalf("通过符号链接读取 %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "models", "config.txt")); err != nil {
+		t.Fatal(err)
+	}
+	checkNoEscape(t, outside)
+}
+
+// TestUnTarTraversal 构造的恶意 tar 包都被拒绝，且没有文件写到解压目录之外
+func TestUnTarTraversal(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"上级目录", []tarEntry{{name: "../evil", body: "x"}}},
+		{"多级上级目录", []tarEntry{{name: "a/../../evil", body: "x"}}},
+		{"绝对路径", []tarEntry{{name: "/evil", body: "x"}}},
+		{"反斜杠", []tarEntry{{name: `..\evil`, body: "x"}}},
+		{"链接到上级目录", []tarEntry{{name: "escape", link: ".."}}},
+		{"链接到绝对路径", []tarEntry{{name: "escape", link: "/tmp"}}},
+		{"链接目标多级跳出", []tarEntry{{name: "a/escape", link: "../../evil"}}},
+		{"符号链接链", []tarEntry{
+			{name: "a/", dir: true},
+			{name: "a/b", link: ".."},
+			{name: "a/b/c", link: ".."},
+			{name: "a/b/c/x", body: "x"},
+		}},
+		{"经过符号链接返回上级", []tarEntry{
+			{name: "a/", dir: true},
+			{name: "a/self", link: "."},
+			{name: "a/up", link: "self/../.."},
+		}},
+		{"通过目录链接写入", []tarEntry{
+			{name: "sub/", dir: true},
+			{name: "link", link: "sub"},
+			{name: "link/file", body: "x"},
+		}},
+		{"覆盖符号链接", []tarEntry{
+			{name: "target", body: "original"},
+			{name: "link", link: "target"},
+			{name: "link", body: "x"},
+		}},
+		{"目录替换为符号链接", []tarEntry{
+			{name: "a/", dir: true},
+			{name: "a", link: "."},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest, outside := extractDirs(t)
+			archive := writeTarGz(t, t.TempDir(), tt.entries)
+			err := unTar(archive, dest)
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("错误为 %v，应为 ErrUnsafePath", err)
+			}
+			checkNoEscape(t, outside)
+		})
+	}
+}
+
+// TestUnZip testdata 中的 7z 文件由 bsdtar --format 7zip 生成，恶意条目名通过 -P -s 改写
+func TestUnZip(t *testing.T) {
+	dest, outside := extractDirs(t)
+	if err := unZip(filepath.Join("testdata", "ok.7z"), dest); err != nil {
+		t.Fatalf("解压失败: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "engine", "bin", "tool"))
+	if err != nil || string(data) != "ok\n" {
+		t.Fatalf("读取解压的文件 %q, %v", data, err)
+	}
+	checkNoEscape(t, outside)
+}
+
+func TestUnZipTraversal(t *testing.T) {
+	for _, name := range []string{"traversal.7z", "nested-traversal.7z", "absolute.7z", "backslash.7z"} {
+		t.Run(name, func(t *testing.T) {
+			dest, outside := extractDirs(t)
+			err := unZip(filepath.Join("testdata", name), dest)
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("错误为 %v，应为 ErrUnsafePath", err)
+			}
+			checkNoEscape(t, outside)
+		})
+	}
+}
+
+// limitExtract 在测试期间调小解压限制
+func limitExtract(t *testing.T, entries int, size int64) {
+	t.Helper()
+	oldEntries, oldSize := maxArchiveEntries, maxExtractedSize
+	maxArchiveEntries, maxExtractedSize = entries, size
+	t.Cleanup(func() { maxArchiveEntries, maxExtractedSize = oldEntries, oldSize })
+}
+
+// TestExtractLimits 条目数或解压后的总大小超过限制时拒绝解压。
+// testdata 中 many-entries.7z 含 5 个文件，oversized.7z 含 2 个 300 字节的文件
+func TestExtractLimits(t *testing.T) {
+	var many, large []tarEntry
+	for i := 0; i < 5; i++ {
+		many = append(many, tarEntry{name: fmt.Sprintf("f%d.txt", i), body: "x"})
+	}
+	for _, name := range []string{"a.bin", "b.bin"} {
+		large = append(large, tarEntry{name: name, body: strings.Repeat("0", 300)})
+	}
+
+	tests := []struct {
+		name    string
+		extract func(dest string) error
+	}{
+		{"tar 条目数", func(dest string) error { return unTar(writeTarGz(t, t.TempDir(), many), dest) }},
+		{"tar 总大小", func(dest string) error { return unTar(writeTarGz(t, t.TempDir(), large), dest) }},
+		{"7z 条目数", func(dest string) error { return unZip(filepath.Join("testdata", "many-entries.7z"), dest) }},
+		{"7z 总大小", func(dest string) error { return unZip(filepath.Join("testdata", "oversized.7z"), dest) }},
+	}
+	// 单个文件都不超过 500 字节，合计超过
+	limitExtract(t, 4, 500)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest, outside := extractDirs(t)
+			if err := tt.extract(dest); !errors.Is(err, ErrArchiveTooLarge) {
+				t.Fatalf("错误为 %v，应为 ErrArchiveTooLarge", err)
+			}
+			checkNoEscape(t, outside)
+		})
+	}
+}
+
+// TestUnTarDefaultEntryLimit 使用默认限制时，超过 maxArchiveEntries 个条目的 tar 包被拒绝
+func TestUnTarDefaultEntryLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("需要创建两万多个目录")
+	}
+	entries := make([]tarEntry, maxArchiveEntries+1)
+	for i := range entries {
+		entries[i] = tarEntry{name: fmt.Sprintf("d%d/", i), dir: true}
+	}
+	dest, _ := extractDirs(t)
+	if err := unTar(writeTarGz(t, t.TempDir(), entries), dest); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Fatalf("错误为 %v，应为 ErrArchiveTooLarge", err)
+	}
+}
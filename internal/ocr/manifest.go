@@ -0,0 +1,186 @@
+package ocr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	manifestFile = "engines.json" // 已安装引擎清单，位于 resDir 下
+	enginesDir   = "engines"      // 各版本引擎的安装目录，位于 resDir 下
+)
+
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// InstalledEngine 清单中的一个引擎版本
+type InstalledEngine struct {
+	Version     string    `json:"version"`
+	ExePath     string    `json:"exe_path"` // 可执行文件路径，相对于工作目录
+	Source      string    `json:"source"`   // 下载地址或本地引擎包路径
+	SHA256      string    `json:"sha256,omitempty"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// Manifest 已安装引擎清单
+type Manifest struct {
+	Active  string            `json:"active"` // 未固定版本时处理器池使用的版本
+	Engines []InstalledEngine `json:"engines"`
+}
+
+// Find 按版本查找已安装的引擎
+func (m *Manifest) Find(version string) (InstalledEngine, bool) {
+	for _, e := range m.Engines {
+		if e.Version == version {
+			return e, true
+		}
+	}
+	return InstalledEngine{}, false
+}
+
+func (m *Manifest) put(engine InstalledEngine) {
+	for i, e := range m.Engines {
+		if e.Version == engine.Version {
+			m.Engines[i] = engine
+			return
+		}
+	}
+	m.Engines = append(m.Engines, engine)
+	sort.Slice(m.Engines, func(i, j int) bool { return m.Engines[i].Version < m.Engines[j].Version })
+}
+
+func (m *Manifest) remove(version string) {
+	for i, e := range m.Engines {
+		if e.Version == version {
+			m.Engines = append(m.Engines[:i], m.Engines[i+1:]...)
+			return
+		}
+	}
+}
+
+func validateVersion(version string) error {
+	if !versionPattern.MatchString(version) {
+		return fmt.Errorf("无效的引擎版本: %q", version)
+	}
+	return nil
+}
+
+func versionDir(version string) string {
+	return filepath.Join(resDir, enginesDir, version)
+}
+
+// loadManifest 读取清单，文件不存在时返回空清单
+func loadManifest() (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(resDir, manifestFile))
+	if os.IsNotExist(err) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取引擎清单失败: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("解析引擎清单失败: %w", err)
+	}
+	return &m, nil
+}
+
+// save 先写临时文件再重命名，避免中断时留下损坏的清单
+func (m *Manifest) save() error {
+	if err := os.MkdirAll(resDir, 0755); err != nil {
+		return fmt.Errorf("创建 res 目录失败: %w", err)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化引擎清单失败: %w", err)
+	}
+	path := filepath.Join(resDir, manifestFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入引擎清单失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("写入引擎清单失败: %w", err)
+	}
+	return nil
+}
+
+// ListEngines 返回已安装引擎清单
+func ListEngines() (Manifest, error) {
+	installLock.Lock()
+	defer installLock.Unlock()
+	m, err := loadManifest()
+	if err != nil {
+		return Manifest{}, err
+	}
+	return *m, nil
+}
+
+// ActivateEngine 将已安装的版本设为默认版本，新创建的处理器会使用该版本
+func ActivateEngine(version string) error {
+	installLock.Lock()
+	defer installLock.Unlock()
+	m, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	if _, ok := m.Find(version); !ok {
+		return fmt.Errorf("引擎版本 %s 未安装", version)
+	}
+	m.Active = version
+	return m.save()
+}
+
+// RemoveEngine 删除已安装的版本，不允许删除当前默认版本
+func RemoveEngine(version string) error {
+	installLock.Lock()
+	defer installLock.Unlock()
+	if err := validateVersion(version); err != nil {
+		return err
+	}
+	m, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	if _, ok := m.Find(version); !ok {
+		return fmt.Errorf("引擎版本 %s 未安装", version)
+	}
+	if m.Active == version {
+		return fmt.Errorf("引擎版本 %s 是当前默认版本，请先激活其他版本", version)
+	}
+	if err := os.RemoveAll(versionDir(version)); err != nil {
+		return fmt.Errorf("删除引擎目录失败: %w", err)
+	}
+	m.remove(version)
+	return m.save()
+}
+
+// registerInstalled 在版本目录中查找可执行文件并写入清单，清单中没有默认版本时设为默认
+func registerInstalled(version, source, sha256 string) (string, error) {
+	ocrPath, err := findEngineExe(versionDir(version))
+	if err != nil {
+		return "", err
+	}
+	m, err := loadManifest()
+	if err != nil {
+		return "", err
+	}
+	m.put(InstalledEngine{
+		Version:     version,
+		ExePath:     ocrPath,
+		Source:      source,
+		SHA256:      sha256,
+		InstalledAt: time.Now(),
+	})
+	if m.Active == "" {
+		m.Active = version
+	}
+	if err := m.save(); err != nil {
+		return "", err
+	}
+	return ocrPath, nil
+}
@@ -2,87 +2,360 @@ package ocr
 
 import (
 	"fmt"
-	"github.com/bodgit/sevenzip"
 	"github.com/cenkalti/backoff/v4"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"ocr-server/logger"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	ocrDownloadURL = "https://github.com/hiroi-sora/PaddleOCR-json/releases/download/v1.4.1/PaddleOCR-json_v1.4.1_windows_x64.7z"
-	ocrFileName    = "PaddleOCR-json.7z"
-	ocrExeName     = "PaddleOCR-json_v1.4.1/PaddleOCR-json.exe"
-	resDir         = "res"
+	resDir = "res"
 )
 
-func EnsureOCREngine() (string, error) {
-	ocrPath := filepath.Join(resDir, ocrExeName)
+// InstallOptions 引擎安装参数
+type InstallOptions struct {
+	Version string `mapstructure:"engine_version" yaml:"engine_version"` // 固定使用的引擎版本，为空时使用清单中的默认版本
+	Bundle  string `mapstructure:"engine_bundle" yaml:"engine_bundle"`   // 预置的引擎压缩包或目录，设置后不访问网络
+	Offline bool   `mapstructure:"offline" yaml:"offline"`               // 离线模式，禁止联网下载引擎
+	Proxy   string `mapstructure:"download_proxy" yaml:"download_proxy"` // 下载代理，为空时使用 HTTP(S)_PROXY 环境变量
 
-	if _, err := os.Stat(ocrPath); err == nil {
-		fmt.Println("OCR 引擎已存在。")
-		return ocrPath, nil
+	SHA256    string `mapstructure:"engine_sha256" yaml:"engine_sha256"`         // 引擎包的 SHA-256，优先于内置的固定值
+	PublicKey string `mapstructure:"engine_public_key" yaml:"engine_public_key"` // base64 编码的 Ed25519 公钥，设置后必须校验签名
+	Signature string `mapstructure:"engine_signature" yaml:"engine_signature"`   // 分离签名的路径或 URL，默认为引擎包地址加 .sig
+
+	AllowUnverified bool `mapstructure:"allow_unverified_engine" yaml:"allow_unverified_engine"` // 允许安装既没有 SHA-256 也没有签名可校验的引擎包
+}
+
+var installLock sync.Mutex
+
+// EnsureOCREngine 确保引擎已安装并返回可执行文件路径。
+// 查找顺序：清单中固定/默认的版本 -> 旧版本直接解压在 res 下的引擎 -> 预置的引擎包 -> 联网下载（离线模式下直接报错），
+// 整个过程不会读取标准输入。
+func EnsureOCREngine(opts InstallOptions) (string, error) {
+	installLock.Lock()
+	defer installLock.Unlock()
+
+	m, err := loadManifest()
+	if err != nil {
+		return "", err
+	}
+	version := opts.Version
+	if version == "" {
+		version = m.Active
+	}
+	if installed, ok := m.Find(version); ok {
+		if _, err := os.Stat(installed.ExePath); err == nil {
+			return installed.ExePath, PrepareEngineEnv(installed.ExePath)
+		}
+		logger.LogWarning("清单中的引擎 %s 已不存在: %s，重新安装", version, installed.ExePath)
+	}
+	if version == "" {
+		if ocrPath, err := findLegacyEngine(); err == nil {
+			return ocrPath, PrepareEngineEnv(ocrPath)
+		}
+		version = engineVersion
+	}
+	opts.Version = version
+
+	if opts.Bundle != "" {
+		logger.LogInfo("未找到 OCR 引擎 %s。从预置引擎包安装: %s", version, opts.Bundle)
+		return installFrom(opts.Bundle, opts)
+	}
+	if opts.Offline {
+		return "", fmt.Errorf("离线模式下未找到 OCR 引擎 %s，请配置 engine_bundle 或执行 install-engine --from <压缩包|目录>", version)
+	}
+	return installDownload(opts)
+}
+
+// InstallVersion 联网下载并安装指定版本，已安装的版本会被覆盖
+func InstallVersion(opts InstallOptions) (string, error) {
+	installLock.Lock()
+	defer installLock.Unlock()
+	if opts.Version == "" {
+		opts.Version = engineVersion
+	}
+	return installDownload(opts)
+}
+
+func installDownload(opts InstallOptions) (string, error) {
+	if err := validateVersion(opts.Version); err != nil {
+		return "", err
+	}
+	artifact, err := resolveArtifact(opts.Version)
+	if err != nil {
+		return "", err
 	}
 
-	logger.LogInfo("未找到 OCR 引擎。开始下载过程...")
-	if err := downloadOCRWithRetry(); err != nil {
+	logger.LogInfo("开始下载 OCR 引擎 %s...", opts.Version)
+	archivePath, sum, err := downloadVerified(artifact, opts)
+	if err != nil {
 		return "", fmt.Errorf("下载 OCR 引擎失败: %w", err)
 	}
 
-	if err := extractArchive(); err != nil {
+	if err := extractArchive(archivePath, versionDir(opts.Version)); err != nil {
 		return "", fmt.Errorf("提取 OCR 引擎失败: %w", err)
 	}
+	go func() {
+		time.Sleep(10 * time.Second)
+		// Remove the archive file after extraction
+		if err := os.Remove(archivePath); err != nil {
+			logger.LogError("警告: 删除文件失败: %v\n", err)
+		}
+	}()
 
-	logger.LogInfo("OCR 引擎安装成功。")
+	ocrPath, err := registerInstalled(opts.Version, artifact.URL, sum)
+	if err != nil {
+		return "", err
+	}
+	if err := PrepareEngineEnv(ocrPath); err != nil {
+		return "", err
+	}
+	logger.LogInfo("OCR 引擎 %s 安装成功。", opts.Version)
 	return ocrPath, nil
 }
 
-func downloadOCRWithRetry() error {
-	var proxyURL string
-	logger.LogInfo("输入代理 URL (留空则直接下载): ")
-	fmt.Scanln(&proxyURL)
-	if proxyURL == "" {
-		fmt.Println("代理 URL，开始下载...")
+// InstallFrom 从本地压缩包或目录安装引擎，不访问网络；
+// opts.Version 为空时从文件名推断版本号。
+// opts 中配置了 SHA-256 或公钥时会先校验压缩包，目录无法校验因此会被拒绝
+func InstallFrom(source string, opts InstallOptions) (string, error) {
+	installLock.Lock()
+	defer installLock.Unlock()
+	return installFrom(source, opts)
+}
+
+func installFrom(source string, opts InstallOptions) (string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", fmt.Errorf("读取引擎包失败: %w", err)
 	}
-	if _, err := os.Stat(resDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(resDir, 0755); err != nil {
-			return fmt.Errorf("创建 res 目录失败: %w", err)
+	if opts.Version == "" {
+		opts.Version = bundleVersion(source)
+	}
+	if err := validateVersion(opts.Version); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(resDir, 0755); err != nil {
+		return "", fmt.Errorf("创建 res 目录失败: %w", err)
+	}
+
+	var sum string
+	if !info.IsDir() {
+		if sum, err = verifyArchive(source, opts.SHA256, opts, source+".sig", nil); err != nil {
+			return "", err
+		}
+	} else if opts.SHA256 != "" || opts.PublicKey != "" {
+		return "", fmt.Errorf("已配置引擎包校验，但 %s 是目录，无法校验", source)
+	}
+
+	if info.IsDir() {
+		if err := installDir(source, versionDir(opts.Version)); err != nil {
+			return "", fmt.Errorf("复制引擎目录失败: %w", err)
+		}
+	} else if err := extractArchive(source, versionDir(opts.Version)); err != nil {
+		return "", fmt.Errorf("提取 OCR 引擎失败: %w", err)
+	}
+
+	ocrPath, err := registerInstalled(opts.Version, source, sum)
+	if err != nil {
+		return "", err
+	}
+	if err := PrepareEngineEnv(ocrPath); err != nil {
+		return "", err
+	}
+	logger.LogInfo("OCR 引擎 %s 安装成功: %s", opts.Version, ocrPath)
+	return ocrPath, nil
+}
+
+var bundleVersionPattern = regexp.MustCompile(`v\d+(\.\d+)+`)
+
+// bundleVersion 从引擎包文件名中推断版本号，例如 PaddleOCR-json_v1.4.1_windows_x64.7z
+func bundleVersion(source string) string {
+	if v := bundleVersionPattern.FindString(filepath.Base(filepath.Clean(source))); v != "" {
+		return v
+	}
+	return "local"
+}
+
+// findLegacyEngine 查找旧版本直接解压在 resDir 下、未登记在清单中的引擎
+func findLegacyEngine() (string, error) {
+	if artifact, err := resolveArtifact(engineVersion); err == nil {
+		ocrPath := filepath.Join(resDir, artifact.ExeName)
+		if _, err := os.Stat(ocrPath); err == nil {
+			return ocrPath, nil
+		}
+	}
+	return "", fmt.Errorf("未找到旧版本的 OCR 引擎")
+}
+
+// findEngineExe 在目录中查找 PaddleOCR-json 可执行文件
+func findEngineExe(root string) (string, error) {
+	exeName := "PaddleOCR-json"
+	if runtime.GOOS == "windows" {
+		exeName += ".exe"
+	}
+	var found string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == exeName {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("查找 OCR 引擎失败: %w", err)
+	}
+	if found == "" {
+		return "", fmt.Errorf("在 %s 中未找到 %s", root, exeName)
+	}
+	return found, nil
+}
+
+// installDir 先复制到临时目录，完成后再整体替换 dest
+func installDir(source, dest string) error {
+	tmpDir, err := os.MkdirTemp(resDir, ".extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := copyDir(source, filepath.Join(tmpDir, filepath.Base(filepath.Clean(source)))); err != nil {
+		return err
+	}
+	return commitDir(tmpDir, dest)
+}
+
+// copyDir 递归复制目录，保留文件权限和符号链接
+func copyDir(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
 		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src, dest string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
 	}
+	return out.Close()
+}
 
-	client := &http.Client{}
+func newHTTPClient(proxyURL string) (*http.Client, error) {
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
 	if proxyURL != "" {
 		proxyURLParsed, err := url.Parse(proxyURL)
 		if err != nil {
-			return fmt.Errorf("无效的代理 URL: %w", err)
+			return nil, fmt.Errorf("无效的代理 URL: %w", err)
 		}
 		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURLParsed)}
 	}
+	return client, nil
+}
 
-	filePath := filepath.Join(resDir, ocrFileName)
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+// downloadVerified 下载到 .part 文件并校验，通过后才重命名为正式的压缩包。
+// 校验失败时丢弃文件；如果是断点续传得到的文件，则从头重新下载一次。
+func downloadVerified(artifact engineArtifact, opts InstallOptions) (string, string, error) {
+	client, err := newHTTPClient(opts.Proxy)
 	if err != nil {
-		return fmt.Errorf("创建文件失败: %w", err)
+		return "", "", err
 	}
-	defer file.Close()
+	expected := opts.SHA256
+	if expected == "" {
+		expected = artifact.SHA256
+	}
+
+	archivePath := filepath.Join(resDir, artifact.FileName())
+	partPath := archivePath + ".part"
+	for attempt := 0; attempt < 2; attempt++ {
+		resumed, err := downloadOCRWithRetry(client, artifact.URL, partPath)
+		if err != nil {
+			return "", "", err
+		}
+		sum, err := verifyArchive(partPath, expected, opts, artifact.URL+".sig", client)
+		if err == nil {
+			if err := os.Rename(partPath, archivePath); err != nil {
+				return "", "", fmt.Errorf("重命名引擎包失败: %w", err)
+			}
+			return archivePath, sum, nil
+		}
+		os.Remove(partPath)
+		if !resumed {
+			return "", "", err
+		}
+		logger.LogWarning("续传的引擎包校验失败，已丢弃并重新下载: %v", err)
+	}
+	return "", "", fmt.Errorf("引擎包校验失败")
+}
 
-	fileInfo, err := file.Stat()
+// downloadOCRWithRetry 下载文件，已存在的部分会通过 Range 续传，返回是否使用了续传
+func downloadOCRWithRetry(client *http.Client, rawURL, filePath string) (bool, error) {
+	if _, err := os.Stat(resDir); os.IsNotExist(err) {
+		if err := os.MkdirAll(resDir, 0755); err != nil {
+			return false, fmt.Errorf("创建 res 目录失败: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
-		return fmt.Errorf("获取文件信息失败: %w", err)
+		return false, fmt.Errorf("创建文件失败: %w", err)
 	}
+	defer file.Close()
 
-	resumePos := fileInfo.Size()
+	resumed := false
 	operation := func() error {
-		req, err := http.NewRequest("GET", ocrDownloadURL, nil)
+		// 每次重试都以文件当前大小作为续传位置，避免重复追加
+		resumePos, err := file.Seek(0, io.SeekEnd)
 		if err != nil {
-			return fmt.Errorf("创建请求失败: %w", err)
+			return fmt.Errorf("获取文件信息失败: %w", err)
 		}
 
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			return backoff.Permanent(fmt.Errorf("创建请求失败: %w", err))
+		}
 		if resumePos > 0 {
 			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumePos))
 		}
@@ -93,7 +366,22 @@ func downloadOCRWithRetry() error {
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		switch {
+		case resp.StatusCode == http.StatusPartialContent && resumePos > 0:
+			resumed = true
+		case resp.StatusCode == http.StatusOK:
+			// 服务器不支持续传，从头写入
+			if err := file.Truncate(0); err != nil {
+				return fmt.Errorf("清空文件失败: %w", err)
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("清空文件失败: %w", err)
+			}
+		case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && resumePos > 0:
+			// 文件可能已经下载完整，交给校验判断
+			resumed = true
+			return nil
+		default:
 			return fmt.Errorf("服务器返回错误状态码: %d", resp.StatusCode)
 		}
 
@@ -109,80 +397,42 @@ func downloadOCRWithRetry() error {
 
 	err = backoff.Retry(operation, backOff)
 	if err != nil {
-		return fmt.Errorf("下载失败: %w", err)
+		return resumed, fmt.Errorf("下载失败: %w", err)
 	}
 
 	logger.LogInfo("\n下载成功完成。")
-	return nil
+	return resumed, nil
 }
-func extractArchive() error {
-	entries, err := os.ReadDir(resDir)
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return err
-	}
-	var ocrFileName string
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			if strings.HasSuffix(entry.Name(), "7z") {
-				ocrFileName = entry.Name()
-			}
-		}
-	}
-	archivePath := filepath.Join(resDir, ocrFileName)
-	err = unZip(archivePath)
-	if err != nil {
-		logger.LogError("提取失败: %v\n\n", err)
-	}
-
-	go func() {
-		time.Sleep(10 * time.Second)
-		// Remove the archive file after extraction
-		if err := os.Remove(archivePath); err != nil {
-			logger.LogError("警告: 删除文件失败: %v\n", err)
-		}
-	}()
 
-	logger.LogInfo("提取成功完成。")
-	return nil
-}
-func unZip(archive string) error {
-	r, err := sevenzip.OpenReader(archive)
-	if err != nil {
-		return fmt.Errorf("打开压缩文件失败: %w", err)
-	}
-	defer r.Close()
-	for _, file := range r.File {
-		path := filepath.Join(resDir, file.Name)
-		if !file.FileInfo().IsDir() {
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-			if err != nil {
-				return err
-			}
-			rc, err := file.Open()
-			if err != nil {
-				f.Close()
-				return err
-			}
-			_, err = io.Copy(f, rc)
-			rc.Close()
-			f.Close()
-			if err != nil {
-				return err
-			}
-		} else {
-			os.MkdirAll(path, os.ModePerm)
-		}
-	}
-	return nil
-}
 func IsOCREngineInstalled() bool {
-	_, err := os.Stat(filepath.Join(resDir, ocrExeName))
+	_, err := os.Stat(GetOCREnginePath())
 	return err == nil
 }
 
+// legacyExePath 旧版本生成配置文件时写入的 ocr_exe_path 默认值
+const legacyExePath = "res/PaddleOCR-json_v1.4.1/PaddleOCR-json.exe"
+
+// IsLegacyExePath 判断 path 是否为旧版本写入配置文件的 ocr_exe_path 默认值，
+// 从旧版本升级的配置文件总是带有该路径，应按未设置处理，由清单选择引擎版本
+func IsLegacyExePath(path string) bool {
+	if path == "" {
+		return false
+	}
+	return filepath.ToSlash(filepath.Clean(strings.ReplaceAll(path, `\`, "/"))) == legacyExePath
+}
+
+// GetOCREnginePath 返回当前默认版本的引擎可执行文件路径，未安装时返回当前平台的默认路径，不支持的平台返回空字符串
 func GetOCREnginePath() string {
-	return filepath.Join(resDir, ocrExeName)
+	if m, err := loadManifest(); err == nil {
+		if installed, ok := m.Find(m.Active); ok {
+			return installed.ExePath
+		}
+	}
+	artifact, err := resolveArtifact(engineVersion)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(resDir, artifact.ExeName)
 }
 
 // ProgressReader is a custom io.Reader that reports progress
@@ -0,0 +1,121 @@
+package ocr
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"ocr-server/logger"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch 压缩包的 SHA-256 与固定值不一致
+var ErrChecksumMismatch = errors.New("引擎包 SHA-256 校验失败")
+
+// ErrSignatureInvalid 压缩包的分离签名校验失败
+var ErrSignatureInvalid = errors.New("引擎包签名校验失败")
+
+// ErrUnverified 压缩包既没有固定的 SHA-256 也没有配置签名公钥，且未允许安装未校验的引擎包
+var ErrUnverified = errors.New("引擎包没有可用的 SHA-256 或签名，拒绝安装")
+
+// verifyArchive 在解压前校验压缩包：
+// expectedSHA256 不为空时必须一致；配置了公钥时必须存在有效的 Ed25519 分离签名。
+// 两者都没有时拒绝安装，除非设置了 allow_unverified_engine，此时只记录实际的哈希值。
+// 返回压缩包实际的 SHA-256
+func verifyArchive(path, expectedSHA256 string, opts InstallOptions, sigSource string, client *http.Client) (string, error) {
+	actual, err := fileSHA256(path)
+	if err != nil {
+		return "", err
+	}
+	if expectedSHA256 == "" && opts.PublicKey == "" {
+		if !opts.AllowUnverified {
+			return "", fmt.Errorf("%w: 实际 SHA-256 为 %s，可配置 engine_sha256 或 engine_public_key，或设置 allow_unverified_engine 跳过校验", ErrUnverified, actual)
+		}
+		logger.LogWarning("引擎包没有可用的 SHA-256 或签名，已按 allow_unverified_engine 跳过校验。实际值: %s（可写入 engine_sha256 固定）", actual)
+	} else if expectedSHA256 != "" && !strings.EqualFold(actual, strings.TrimSpace(expectedSHA256)) {
+		return "", fmt.Errorf("%w: 期望 %s，实际 %s", ErrChecksumMismatch, expectedSHA256, actual)
+	}
+
+	if opts.PublicKey == "" {
+		return actual, nil
+	}
+	if opts.Signature != "" {
+		sigSource = opts.Signature
+	}
+	sig, err := loadSignature(sigSource, client)
+	if err != nil {
+		return "", err
+	}
+	return actual, verifySignature(path, opts.PublicKey, sig)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("打开引擎包失败: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("计算 SHA-256 失败: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadSignature 从本地文件或 URL 读取签名，支持原始 64 字节或 base64 文本
+func loadSignature(source string, client *http.Client) ([]byte, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		if client == nil {
+			return nil, fmt.Errorf("离线模式下无法下载签名: %s", source)
+		}
+		data, err = fetch(client, source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取引擎包签名失败: %w", err)
+	}
+	if len(data) == ed25519.SignatureSize {
+		return data, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: 签名格式无效", ErrSignatureInvalid)
+	}
+	return sig, nil
+}
+
+// verifySignature 使用 base64 编码的 Ed25519 公钥校验整个压缩包
+func verifySignature(path, publicKey string, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("无效的引擎包公钥")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取引擎包失败: %w", err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func fetch(client *http.Client, rawURL string) ([]byte, error) {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务器返回错误状态码: %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
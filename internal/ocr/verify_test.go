@@ -0,0 +1,75 @@
+package ocr
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVerifyArchive 覆盖哈希一致、哈希不一致、签名无效、没有可用的哈希或签名以及显式跳过校验
+func TestVerifyArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "engine.tar.gz")
+	content := []byte("engine archive")
+	if err := os.WriteFile(archive, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKey := base64.StdEncoding.EncodeToString(publicKey)
+	writeSig := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	goodSig := writeSig("good.sig", content)
+	badSig := writeSig("bad.sig", []byte("another archive"))
+
+	tests := []struct {
+		name     string
+		expected string
+		opts     InstallOptions
+		err      error
+	}{
+		{"哈希一致", digest, InstallOptions{}, nil},
+		{"哈希大写且带空白", " " + strings.ToUpper(digest) + "\n", InstallOptions{}, nil},
+		{"哈希不一致", strings.Repeat("0", 64), InstallOptions{}, ErrChecksumMismatch},
+		{"哈希不一致时跳过校验也不能安装", strings.Repeat("0", 64), InstallOptions{AllowUnverified: true}, ErrChecksumMismatch},
+		{"签名有效", "", InstallOptions{PublicKey: encodedKey, Signature: goodSig}, nil},
+		{"签名无效", "", InstallOptions{PublicKey: encodedKey, Signature: badSig}, ErrSignatureInvalid},
+		{"哈希一致但签名无效", digest, InstallOptions{PublicKey: encodedKey, Signature: badSig}, ErrSignatureInvalid},
+		{"没有哈希和签名", "", InstallOptions{}, ErrUnverified},
+		{"没有哈希和签名但允许跳过校验", "", InstallOptions{AllowUnverified: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := verifyArchive(archive, tt.expected, tt.opts, archive+".sig", nil)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("错误为 %v，应为 %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("校验失败: %v", err)
+			}
+			if actual != digest {
+				t.Fatalf("返回的 SHA-256 为 %s，应为 %s", actual, digest)
+			}
+		})
+	}
+}
@@ -16,105 +16,128 @@ import (
 	"github.com/doraemonkeys/paddleocr"
 )
 
+// OCRProcessor 处理器池中的一个引擎实例。处理器同一时间只属于一个使用者（任务、回收或健康检查），
+// 使用者由池锁保护的 inUse 标记
 type OCRProcessor struct {
-	processor  *paddleocr.Ppocr //处理器
+	processor  ocrengine.Engine //处理器
 	usageCount int64            //使用数量
 	lastUsed   time.Time        //最后使用时间
-	mutex      sync.Mutex
+	mutex      sync.Mutex       // 保护 processor、lastUsed 和 startedAt，只在读写这些字段时短暂持有
 	inUse      bool
+	jobs       int64     // 当前引擎实例处理过的任务数，原子访问
+	totalJobs  int64     // 处理器生命周期内处理过的任务数，原子访问
+	startedAt  time.Time // 当前引擎实例的创建时间
+	recycles   int64     // 引擎被回收替换的次数，原子访问
+	discarded  bool      // 处理器已移出池并归还引擎名额，受 mutex 保护
 }
 
 type ocrTask struct {
+	Engine      string          // 引擎名称，为空时使用默认引擎
+	Model       string          // 模型名称，为空时使用引擎的默认配置
+	Priority    string          // 优先级队列名称
+	Client      *clientState    // 提交任务的客户端
+	Ctx         context.Context // 请求的 context，客户端断开连接后取消，为 nil 表示不随请求取消
+	EnqueuedAt  time.Time
+	slot        *lane          // 任务占用容量的队列，shed 策略下可能是被挤出任务所在的低优先级队列
+	pool        *processorPool // 任务使用的处理器池，出队时检查池的执行名额，由 submit 设置
 	ImagePath   string
 	ImageFormat string
 	ImageData   []byte
 	Response    chan ocrResponse
-}
+	onStart     func() // 任务出队开始处理时调用，可以为 nil
 
-func (s *Server) createOCRProcessor() (*OCRProcessor, error) {
-	processor, err := ocrengine.NewOCREngine(s.config.OCRExePath)
-	if err != nil {
-		return nil, err
-	}
-	return &OCRProcessor{
-		processor: processor.Ppocr,
-		lastUsed:  time.Now(),
-	}, nil
+	ThresholdMode  int // 二值化阈值模式
+	ThresholdValue int // 二值化阈值
 }
 
-func (s *Server) getAvailableProcessor(ctx context.Context) *OCRProcessor {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			if len(s.idleProcessors) > 0 {
-				processor := s.idleProcessors[len(s.idleProcessors)-1]
-				s.idleProcessors = s.idleProcessors[:len(s.idleProcessors)-1]
-				s.activeProcessors = append(s.activeProcessors, processor)
-				processor.inUse = true
-				return processor
-			}
-
-			if len(s.activeProcessors) < s.config.MaxProcessors {
-				processor, err := s.createOCRProcessor()
-				if err == nil {
-					processor.inUse = true
-					s.activeProcessors = append(s.activeProcessors, processor)
-					return processor
-				}
-			}
-
-			s.processorCond.Wait()
-		}
-	}
+// engine 返回处理器当前的引擎
+func (processor *OCRProcessor) engine() ocrengine.Engine {
+	processor.mutex.Lock()
+	defer processor.mutex.Unlock()
+	return processor.processor
 }
 
-func (s *Server) releaseProcessor(processor *OCRProcessor) {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	processor.inUse = false
+// touch 记录处理器的使用时间
+func (processor *OCRProcessor) touch() {
+	processor.mutex.Lock()
+	defer processor.mutex.Unlock()
 	processor.lastUsed = time.Now()
+}
 
-	if len(s.activeProcessors) > s.config.MinProcessors {
-		for i, p := range s.activeProcessors {
-			if p == processor {
-				s.activeProcessors = append(s.activeProcessors[:i], s.activeProcessors[i+1:]...)
-				s.idleProcessors = append(s.idleProcessors, processor)
-				break
-			}
-		}
-	}
-	s.processorCond.Signal()
+// idleFor 处理器距上次使用的时间
+func (processor *OCRProcessor) idleFor() time.Duration {
+	processor.mutex.Lock()
+	defer processor.mutex.Unlock()
+	return time.Since(processor.lastUsed)
+}
+
+// age 当前引擎实例已运行的时间
+func (processor *OCRProcessor) age() time.Duration {
+	processor.mutex.Lock()
+	defer processor.mutex.Unlock()
+	return time.Since(processor.startedAt)
 }
 
 func (s *Server) processTask(ctx context.Context, task ocrTask) {
 	defer s.wg.Done()
 
 	startTime := time.Now()
-	processor := s.getAvailableProcessor(ctx)
-	defer s.releaseProcessor(processor)
-	if processor == nil {
-		logger.LogInfo("无可用处理器，服务器正在关闭")
-		task.Response <- ocrResponse{Error: "服务器正在关闭"}
+	if task.cancelled() {
+		logger.LogInfo("客户端已断开，跳过排队中的任务")
+		atomic.AddInt64(&s.stats.CancelledRequests, 1)
+		return
+	}
+	if task.onStart != nil {
+		task.onStart()
+	}
+	if s.config.TaskTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.TaskTimeout)
+		defer cancel()
+	}
+	// ctx 只在超时或服务器关闭时结束；taskCtx 在客户端断开时也会结束，中断等待处理器、识别和重试
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if task.Ctx != nil {
+		stop := context.AfterFunc(task.Ctx, cancel)
+		defer stop()
+	}
+	source, image, err := s.prepareImage(task)
+	if err != nil {
+		logger.LogInfo("图像预处理失败: %v", err)
+		task.Response <- errorResponse(err)
 		s.updateStats(time.Since(startTime), false)
 		return
 	}
+	pool := s.getPool(task.Engine, task.Model)
+	if err := pool.breaker.allow(); err != nil {
+		logger.LogInfo("[%s] 熔断中，拒绝任务", pool.name)
+		s.fail(task, err, pool, startTime)
+		return
+	}
+	processor, err := pool.acquire(taskCtx)
+	if err != nil && task.cancelled() {
+		logger.LogInfo("[%s] 客户端已断开，放弃等待处理器", pool.name)
+		atomic.AddInt64(&s.stats.CancelledRequests, 1)
+		return
+	}
+	if err != nil {
+		logger.LogInfo("[%s] 获取处理器失败: %v", pool.name, err)
+		s.fail(task, err, pool, startTime)
+		return
+	}
+	defer pool.release(processor)
 
-	logger.LogInfo("使用处理器 %p 处理任务", processor)
-	result, err := s.performOCRWithRetry(ctx, processor, task)
+	logger.LogInfo("使用 %s 处理器 %p 处理任务", pool.name, processor)
+	// 引擎收到的是二值化后的图像，原始图像随 ctx 传递，fake 引擎按它匹配预设结果
+	result, err := s.performOCRWithRetry(ocrengine.WithSourceImage(ctx, source), ocrengine.WithSourceImage(taskCtx, source), pool, processor, image)
 
-	if err != nil {
+	if err != nil && task.cancelled() {
+		logger.LogInfo("客户端已断开，OCR 任务已中止")
+		atomic.AddInt64(&s.stats.CancelledRequests, 1)
+	} else if err != nil {
 		logger.LogInfo("OCR 任务失败: %v", err)
-		task.Response <- ocrResponse{Error: err.Error()}
-		s.updateStats(time.Since(startTime), false)
-	} else if result.Code != paddleocr.CodeSuccess {
-		logger.LogInfo("OCR 任务失败，错误代码: %s", result.Msg)
-		task.Response <- ocrResponse{Error: fmt.Sprintf("OCR 失败: %s", result.Msg)}
-		s.updateStats(time.Since(startTime), false)
+		s.fail(task, err, pool, startTime)
 	} else {
 		logger.LogInfo("OCR 任务成功完成")
 		task.Response <- ocrResponse{Data: result.Data}
@@ -122,57 +145,115 @@ func (s *Server) processTask(ctx context.Context, task ocrTask) {
 	}
 }
 
-func (s *Server) performOCRWithRetry(ctx context.Context, processor *OCRProcessor, task ocrTask) (paddleocr.Result, error) {
+// fail 返回任务错误，熔断时 Retry-After 为剩余熔断时间
+func (s *Server) fail(task ocrTask, err error, pool *processorPool, startTime time.Time) {
+	response := errorResponse(err)
+	if response.Code == codeCircuitOpen {
+		response.retryAfter = pool.breaker.retryIn()
+	}
+	task.Response <- response
+	s.updateStats(time.Since(startTime), false)
+}
+
+// prepareImage 读取并二值化任务图像，返回原始图像和交给引擎的图像，图像无法读取或解码时返回 bad_input 错误
+func (s *Server) prepareImage(task ocrTask) (source, image []byte, err error) {
+	buff := task.ImageData
+	var imageFormat string
+	if task.ImagePath != "" {
+		if imageFormat, err = utils.DetectImageFormat(task.ImagePath); err != nil {
+			return nil, nil, badInput(err)
+		}
+		if buff, err = os.ReadFile(task.ImagePath); err != nil { // 获取文件二进制流
+			return nil, nil, badInput(fmt.Errorf("读取图像失败: %w", err))
+		}
+	}
+	// 二值化
+	threshold := task.ThresholdValue
+	thresholdMode := imgproc.ThresholdMode(task.ThresholdMode)
+	img, err := imgproc.BytesToImage(buff)
+	if err != nil {
+		logger.LogError("图像字节转Image失败: %v", err)
+		return nil, nil, badInput(fmt.Errorf("无法解码图像: %w", err))
+	}
+	processedImg := imgproc.ProcessImage(img, uint8(threshold), thresholdMode)
+	var imgData []byte
+	if imageFormat != "" {
+		imgData, err = imgproc.GrayImageToBytes(processedImg, imageFormat)
+	} else {
+		// 上传的图片不按原格式重新编码，PNG 无损
+		imgData, err = imgproc.GrayImageToPNGBytes(processedImg)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("编码图像失败: %w", err)
+	}
+	return buff, imgData, nil
+}
+
+// cancelled 提交任务的客户端是否已断开
+func (t ocrTask) cancelled() bool {
+	return t.Ctx != nil && t.Ctx.Err() != nil
+}
+
+// performOCRWithRetry 识别图像，引擎出错时替换引擎并按指数退避重试；
+// 引擎报告的失败、取消和熔断不重试。
+// 常驻进程的引擎中断识别需要结束子进程，因此只在 ctx 结束（超时或服务器关闭）时中断，
+// 客户端断开（taskCtx 结束）时完成当前识别后丢弃结果；其他引擎在 taskCtx 结束时中断
+func (s *Server) performOCRWithRetry(ctx, taskCtx context.Context, pool *processorPool, processor *OCRProcessor, image []byte) (paddleocr.Result, error) {
 	var result paddleocr.Result
-	var err error
 
 	operation := func() error {
 		select {
-		case <-ctx.Done():
-			return ctx.Err()
+		case <-taskCtx.Done():
+			return taskCtx.Err()
 		default:
+			if pool.breaker.isOpen() {
+				return backoff.Permanent(errCircuitOpen)
+			}
 			atomic.AddInt64(&processor.usageCount, 1)
 			defer atomic.AddInt64(&processor.usageCount, -1)
-			processor.mutex.Lock()
-			defer processor.mutex.Unlock()
-
-			var buff []byte
-			var imageFormat string
-			if task.ImagePath != "" {
-				imageFormat, err = utils.DetectImageFormat(task.ImagePath)
-				if err != nil {
-					return err
-				}
-				buff, err = os.ReadFile(task.ImagePath) // 获取文件二进制流
-			} else {
-				buff = task.ImageData
-			}
-			// 二值化
-			threshold := s.config.ThresholdValue
-			thresholdMode := imgproc.ThresholdMode(s.config.ThresholdMode)
-			img, err := imgproc.BytesToImage(buff)
-			if err != nil {
-				logger.LogError("图像字节转Image失败: %v", err)
-				return err
+
+			engine := processor.engine()
+			_, resident := engine.(ocrengine.Process)
+			recognizeCtx := taskCtx
+			if resident {
+				recognizeCtx = ctx
 			}
-			processedImg := imgproc.ProcessImage(img, uint8(threshold), thresholdMode)
-			imgData, _ := imgproc.GrayImageToBytes(processedImg, imageFormat)
-			task.ImageData = imgData
-			result, err = processor.processor.OcrAndParse(task.ImageData)
-			processor.lastUsed = time.Now()
+			var err error
+			result, err = engine.Recognize(recognizeCtx, image)
+			processor.touch()
+			atomic.AddInt64(&processor.jobs, 1)
+			atomic.AddInt64(&processor.totalJobs, 1)
+			atomic.AddInt64(&pool.totalJobs, 1)
 
+			if err != nil && recognizeCtx.Err() != nil {
+				// 识别被中断，不再重试；常驻进程的引擎已结束子进程，替换后再交还处理器
+				if resident {
+					if initErr := pool.replaceEngine(processor); initErr != nil {
+						logger.LogError("重新初始化 OCR 处理器失败: %v", initErr)
+					}
+				}
+				return backoff.Permanent(recognizeCtx.Err())
+			}
+			pool.breaker.record(err != nil)
 			if err != nil {
 				logger.LogInfo("OCR 处理器失败: %v。尝试重新初始化...", err)
-				processor.processor.Close()
-				newProcessor, initErr := s.createOCRProcessor()
-				if initErr != nil {
+				if initErr := pool.replaceEngine(processor); initErr != nil {
 					logger.LogError("重新初始化 OCR 处理器失败: %v", initErr)
-					return err // 返回原始错误，让 backoff 重试
+				} else {
+					logger.LogInfo("成功重新初始化 OCR 处理器")
+				}
+				if pool.breaker.isOpen() {
+					return backoff.Permanent(fmt.Errorf("%w: %v", errCircuitOpen, err))
 				}
-				*processor = *newProcessor
-				processor.inUse = true
-				logger.LogInfo("成功重新初始化 OCR 处理器")
-				return err // 返回原始错误，让 backoff 重试
+				return engineError(err) // 让 backoff 重试
+			}
+			if taskCtx.Err() != nil {
+				// 客户端已断开，识别结果不再需要
+				return backoff.Permanent(taskCtx.Err())
+			}
+			if result.Code != paddleocr.CodeSuccess {
+				logger.LogInfo("OCR 任务失败，错误代码: %d %s", result.Code, result.Msg)
+				return backoff.Permanent(engineFailure(result))
 			}
 			return nil
 		}
@@ -181,8 +262,11 @@ func (s *Server) performOCRWithRetry(ctx context.Context, processor *OCRProcesso
 	backOff := backoff.NewExponentialBackOff()
 	backOff.MaxElapsedTime = 2 * time.Minute
 
-	err = backoff.Retry(operation, backoff.WithContext(backOff, ctx))
-	if err != nil {
+	if err := backoff.Retry(operation, backoff.WithContext(backOff, taskCtx)); err != nil {
+		if taskCtx.Err() != nil {
+			// 退避等待期间超时或被取消时 Retry 返回上一次的引擎错误，改为超时、关闭或取消的原因
+			err = context.Cause(taskCtx)
+		}
 		return result, fmt.Errorf("执行 OCR 失败: %w", err)
 	}
 	return result, nil
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	throughputWindow = 60                // 统计吞吐量的时间窗口（秒）
+	maxRetryAfter    = 120 * time.Second // Retry-After 的上限，吞吐量为 0 时也使用该值
+)
+
+// rateMeter 统计最近 throughputWindow 秒内每秒完成的任务数
+type rateMeter struct {
+	mutex   sync.Mutex
+	started time.Time
+	counts  [throughputWindow]int64
+	seconds [throughputWindow]int64 // 每个计数对应的 Unix 秒
+}
+
+func newRateMeter() *rateMeter {
+	return &rateMeter{started: time.Now()}
+}
+
+func (m *rateMeter) record() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now().Unix()
+	i := now % throughputWindow
+	if m.seconds[i] != now {
+		m.seconds[i] = now
+		m.counts[i] = 0
+	}
+	m.counts[i]++
+}
+
+// rate 每秒完成的任务数，启动不足一个窗口时按已运行时间计算
+func (m *rateMeter) rate() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now().Unix()
+	var total int64
+	for i, sec := range m.seconds {
+		if now-sec < throughputWindow {
+			total += m.counts[i]
+		}
+	}
+	window := min(time.Since(m.started).Seconds(), throughputWindow)
+	return float64(total) / max(window, 1)
+}
+
+// admissionCounters 按响应状态统计被拒绝的请求，原子访问
+type admissionCounters struct {
+	overloaded int64 // 服务器无法处理返回 503（队列已满、被挤出、熔断）
+	limited    int64 // 客户端超出自身限制返回 429（每日配额）
+}
+
+// retryAfter 按当前排队任务数和最近的吞吐量估算队列腾出空位的时间
+func (s *Server) retryAfter() time.Duration {
+	rate := s.throughput.rate()
+	if rate <= 0 {
+		return maxRetryAfter
+	}
+	wait := time.Duration(float64(s.lanes.length()+1) / rate * float64(time.Second))
+	return min(max(wait, time.Second), maxRetryAfter)
+}
+
+// backpressure 统计 503 和 429 响应并设置 Retry-After：503 使用 response.retryAfter，
+// 为 0 时按吞吐量估算；429 为距离配额重置的时间
+func (s *Server) backpressure(w http.ResponseWriter, response ocrResponse) {
+	switch response.status {
+	case http.StatusServiceUnavailable:
+		atomic.AddInt64(&s.admission.overloaded, 1)
+		retryAfter := response.retryAfter
+		if retryAfter <= 0 {
+			retryAfter = s.retryAfter()
+		}
+		setRetryAfter(w, retryAfter)
+	case http.StatusTooManyRequests:
+		atomic.AddInt64(&s.admission.limited, 1)
+		setRetryAfter(w, quotaResetIn())
+	}
+}
+
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// admissionStats 准入控制的统计信息
+type admissionStats struct {
+	Policy      string  `json:"policy"`
+	WaitSeconds float64 `json:"wait_seconds"`
+	Throughput  float64 `json:"throughput_per_second"` // 最近一分钟每秒完成的任务数
+	RetryAfter  float64 `json:"retry_after_seconds"`   // 当前返回 503 时的 Retry-After
+	Overloaded  int64   `json:"rejected_503"`
+	Limited     int64   `json:"rejected_429"`
+}
+
+func (s *Server) admissionStats() admissionStats {
+	return admissionStats{
+		Policy:      s.lanes.policy,
+		WaitSeconds: s.lanes.wait.Seconds(),
+		Throughput:  s.throughput.rate(),
+		RetryAfter:  math.Ceil(s.retryAfter().Seconds()),
+		Overloaded:  atomic.LoadInt64(&s.admission.overloaded),
+		Limited:     atomic.LoadInt64(&s.admission.limited),
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"ocr-server/internal/config"
+	"ocr-server/logger"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxWaitSamples   = 1024 // 每个周期最多保留的等待时间样本数
+	scaleDownSamples = 3    // 连续多少个周期利用率偏低才缩容
+)
+
+// autoscaler 处理器池的伸缩状态。
+// 每个周期根据等待中的任务数、队列中排队的任务数、等待时间 P95 和利用率计算目标处理器数：
+//   - 有任务在等待处理器、排队的任务多于空闲处理器、P95 超过 scale_wait_target 或利用率达到 scale_threshold 时扩容；
+//   - 利用率不高于 degrade_threshold 且连续 scaleDownSamples 个周期没有等待时缩容，
+//     每次只关闭一个空闲超过 idle_timeout 的处理器。
+//
+// 两个阈值之间的区间不做调整，扩容和缩容各自有冷却时间，避免负载波动时反复创建和关闭进程。
+type autoscaler struct {
+	scaleThreshold   int64
+	degradeThreshold int64
+	waitTarget       time.Duration
+	upCooldown       time.Duration
+	downCooldown     time.Duration
+
+	mutex      sync.Mutex
+	waits      []time.Duration // 本周期的等待时间样本
+	lowSamples int             // 连续低利用率的周期数
+	lastUp     time.Time
+	lastDown   time.Time
+	last       scaleSnapshot // 最近一个周期的观测结果
+}
+
+// scaleSnapshot 一个伸缩周期的观测结果，出现在 /stats 中
+type scaleSnapshot struct {
+	Utilization  float64   `json:"utilization"`      // 使用中的处理器占比（%）
+	Waiting      int64     `json:"waiting"`          // 等待处理器的任务数
+	Queued       int64     `json:"queued"`           // 队列中排队、将使用该池的任务数
+	WaitP50      float64   `json:"wait_p50_seconds"` // 本周期等待时间的中位数
+	WaitP95      float64   `json:"wait_p95_seconds"`
+	Target       int       `json:"target_processors"`
+	LastAction   string    `json:"last_action,omitempty"` // scale_up 或 scale_down
+	LastActionAt time.Time `json:"last_action_at,omitempty"`
+}
+
+func newAutoscaler(cfg config.Config) autoscaler {
+	return autoscaler{
+		scaleThreshold:   cfg.ScaleThreshold,
+		degradeThreshold: cfg.DegradeThreshold,
+		waitTarget:       cfg.ScaleWaitTarget,
+		upCooldown:       cfg.ScaleUpCooldown,
+		downCooldown:     cfg.ScaleDownCooldown,
+	}
+}
+
+// recordWait 记录一次 acquire 的等待时间（包括按需创建处理器的时间）
+func (p *processorPool) recordWait(d time.Duration) {
+	p.scaler.mutex.Lock()
+	defer p.scaler.mutex.Unlock()
+	if len(p.scaler.waits) < maxWaitSamples {
+		p.scaler.waits = append(p.scaler.waits, d)
+	}
+}
+
+// percentile 返回已排序样本的分位数，q 取 0-1
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * q)
+	return sorted[i]
+}
+
+// autoscale 执行一次伸缩决策，queued 为队列中排队、将使用该池的任务数。
+// processQueue 不会让任务出队到已占满的池，这些任务留在队列中而不计入 waiting，只看 waiting 会低估需求
+func (p *processorPool) autoscale(queued int64) {
+	now := time.Now()
+	waiting := atomic.LoadInt64(&p.waiting)
+
+	p.poolLock.Lock()
+	size := len(p.activeProcessors) + len(p.idleProcessors)
+	inUse := 0
+	for _, processor := range p.activeProcessors {
+		if processor.inUse {
+			inUse++
+		}
+	}
+	p.poolLock.Unlock()
+
+	utilization := float64(0)
+	if size > 0 {
+		utilization = float64(inUse) * 100 / float64(size)
+	} else if waiting > 0 {
+		utilization = 100
+	}
+	floor := min(p.minProcessors+p.warmUpCount, p.maxProcessors)
+	idle := int64(size - inUse)
+	demand := waiting + max(queued-idle, 0) // 超出空闲处理器的需求
+
+	sc := &p.scaler
+	sc.mutex.Lock()
+	waits := sc.waits
+	sc.waits = nil
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	p50, p95 := percentile(waits, 0.5), percentile(waits, 0.95)
+
+	target := size
+	switch {
+	case demand > 0 || utilization >= float64(sc.scaleThreshold) || (sc.waitTarget > 0 && p95 > sc.waitTarget):
+		sc.lowSamples = 0
+		if now.Sub(sc.lastUp) >= sc.upCooldown {
+			target = min(size+max(int(demand), 1), p.maxProcessors)
+		}
+	case utilization <= float64(sc.degradeThreshold):
+		sc.lowSamples++
+		if sc.lowSamples >= scaleDownSamples && now.Sub(sc.lastDown) >= sc.downCooldown && now.Sub(sc.lastUp) >= sc.downCooldown {
+			target = max(size-1, floor)
+		}
+	default:
+		sc.lowSamples = 0
+	}
+	sc.last.Utilization = utilization
+	sc.last.Waiting = waiting
+	sc.last.Queued = queued
+	sc.last.WaitP50 = p50.Seconds()
+	sc.last.WaitP95 = p95.Seconds()
+	sc.last.Target = target
+	sc.mutex.Unlock()
+
+	switch {
+	case target > size:
+		if added := p.scaleUp(target - size); added > 0 {
+			p.recordScaleAction("scale_up", now)
+			logger.LogInfo("[%s] 扩容 %d 个处理器：利用率 %.0f%%，等待 %d，排队 %d，P95 等待 %v", p.name, added, utilization, waiting, queued, p95)
+		}
+	case target < size:
+		if removed := p.scaleDown(size - target); removed > 0 {
+			p.recordScaleAction("scale_down", now)
+			logger.LogInfo("[%s] 缩容 %d 个处理器：利用率 %.0f%%", p.name, removed, utilization)
+		}
+	}
+}
+
+func (p *processorPool) recordScaleAction(action string, at time.Time) {
+	p.scaler.mutex.Lock()
+	defer p.scaler.mutex.Unlock()
+	if action == "scale_up" {
+		p.scaler.lastUp = at
+	} else {
+		p.scaler.lastDown = at
+		p.scaler.lowSamples = 0
+	}
+	p.scaler.last.LastAction = action
+	p.scaler.last.LastActionAt = at
+}
+
+// scaleUp 创建 n 个处理器放入空闲池，创建时不持有池锁；返回实际加入的数量
+func (p *processorPool) scaleUp(n int) int {
+	added := 0
+	for i := 0; i < n; i++ {
+		processor, err := p.createOCRProcessor()
+		if err != nil {
+			logger.LogError("[%s] 扩容时创建处理器失败：%v", p.name, err)
+			break
+		}
+		p.poolLock.Lock()
+		full := p.closed || len(p.activeProcessors)+len(p.idleProcessors) >= p.maxProcessors
+		if !full {
+			p.idleProcessors = append(p.idleProcessors, processor)
+			added++
+		}
+		p.poolLock.Unlock()
+		if full {
+			p.discard(processor)
+			break
+		}
+	}
+	return added
+}
+
+// scaleDown 关闭至多 n 个空闲超过 idle_timeout 的空闲处理器，返回实际关闭的数量
+func (p *processorPool) scaleDown(n int) int {
+	var removed []*OCRProcessor
+	p.poolLock.Lock()
+	for i := len(p.idleProcessors) - 1; i >= 0 && len(removed) < n; i-- {
+		processor := p.idleProcessors[i]
+		if !processor.inUse && processor.idleFor() > p.idleTimeout {
+			p.idleProcessors = append(p.idleProcessors[:i], p.idleProcessors[i+1:]...)
+			removed = append(removed, processor)
+		}
+	}
+	p.poolLock.Unlock()
+	for _, processor := range removed {
+		p.discard(processor)
+	}
+	return len(removed)
+}
+
+// trimIdle 关闭空闲超过 idle_timeout 的处理器，最少保留 min_processors + warm_up_count 个。
+// 关闭自动伸缩（scale_interval 为 0）时由监控周期调用，处理器数量仍会在空闲后回落
+func (p *processorPool) trimIdle() {
+	p.poolLock.Lock()
+	excess := len(p.activeProcessors) + len(p.idleProcessors) - min(p.minProcessors+p.warmUpCount, p.maxProcessors)
+	p.poolLock.Unlock()
+	if excess <= 0 {
+		return
+	}
+	if removed := p.scaleDown(excess); removed > 0 {
+		logger.LogInfo("[%s] 关闭 %d 个空闲超过 %v 的处理器", p.name, removed, p.idleTimeout)
+	}
+}
+
+// scaleStats 返回最近一个伸缩周期的观测结果
+func (p *processorPool) scaleStats() scaleSnapshot {
+	p.scaler.mutex.Lock()
+	defer p.scaler.mutex.Unlock()
+	return p.scaler.last
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"ocr-server/internal/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAutoscaleSimulation 模拟负载变化：有任务等待时扩容到 max_processors，
+// 负载消失后连续多个低利用率周期才缩容，每次只关闭一个空闲处理器，最少保留 min_processors
+func TestAutoscaleSimulation(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors:    1,
+		MaxProcessors:    4,
+		ScaleThreshold:   75,
+		DegradeThreshold: 25,
+	})
+	pool, tracker := newTrackedPool(t, s)
+	size := func() int {
+		st := pool.stats()
+		return st.Active + st.Idle
+	}
+
+	// 占用唯一的处理器，并模拟 3 个等待中的任务
+	held, err := pool.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("获取处理器失败: %v", err)
+	}
+	atomic.AddInt64(&pool.waiting, 3)
+	pool.autoscale(0)
+	if n := size(); n != 4 {
+		t.Fatalf("有任务等待时处理器数为 %d，应扩容到 4", n)
+	}
+	if st := pool.scaleStats(); st.LastAction != "scale_up" || st.Target != 4 {
+		t.Fatalf("伸缩记录为 %+v，应为 scale_up 到 4", st)
+	}
+	atomic.AddInt64(&pool.waiting, -3)
+
+	// 所有处理器都在使用时利用率为 100%，已达上限，不再扩容
+	var all []*OCRProcessor
+	for i := 0; i < 3; i++ {
+		processor, err := pool.acquire(context.Background())
+		if err != nil {
+			t.Fatalf("获取处理器失败: %v", err)
+		}
+		all = append(all, processor)
+	}
+	pool.autoscale(0)
+	if n := size(); n != 4 {
+		t.Fatalf("已达 max_processors 时处理器数为 %d", n)
+	}
+
+	// 负载消失后，连续 scaleDownSamples 个低利用率周期才缩容一个处理器
+	pool.release(held)
+	for _, processor := range all {
+		pool.release(processor)
+	}
+	for i := 1; i < scaleDownSamples; i++ {
+		pool.autoscale(0)
+		if n := size(); n != 4 {
+			t.Fatalf("第 %d 个低利用率周期就缩容到了 %d", i, n)
+		}
+	}
+	for want := 3; want >= 1; want-- {
+		for i := 0; i < scaleDownSamples; i++ {
+			pool.autoscale(0)
+		}
+		if n := size(); n != want {
+			t.Fatalf("缩容后处理器数为 %d，应为 %d", n, want)
+		}
+	}
+	for i := 0; i < 2*scaleDownSamples; i++ {
+		pool.autoscale(0)
+	}
+	if n := size(); n != 1 {
+		t.Fatalf("处理器数为 %d，不应少于 min_processors", n)
+	}
+	checkPool(t, pool, tracker)
+	if open := tracker.open(); open != 1 {
+		t.Fatalf("缩容后仍有 %d 个引擎未关闭，应为 1", open)
+	}
+}
+
+// TestAutoscaleQueued 任务还在队列中、没有任务在池中等待时，排队数超过空闲处理器也会扩容
+func TestAutoscaleQueued(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors:    1,
+		MaxProcessors:    4,
+		ScaleThreshold:   75,
+		DegradeThreshold: 25,
+	})
+	pool, tracker := newTrackedPool(t, s)
+
+	l, _ := s.lanes.lane("")
+	client := newClientState("bulk", config.ClientLimits{})
+	for _, model := range []string{"", "", "", "other"} {
+		if err := s.lanes.enqueue(context.Background(), l, ocrTask{Model: model, Client: client, Response: make(chan ocrResponse, 1)}); err != nil {
+			t.Fatalf("任务入队失败: %v", err)
+		}
+	}
+	queued := s.lanes.queuedByPool(s.config.Engine)
+	if queued[pool.name] != 3 || queued[poolName(s.config.Engine, "other")] != 1 {
+		t.Fatalf("各池排队数为 %v", queued)
+	}
+
+	// 1 个空闲处理器、3 个排队任务，扩容 2 个
+	pool.autoscale(int64(queued[pool.name]))
+	st := pool.stats()
+	if n := st.Active + st.Idle; n != 3 {
+		t.Fatalf("排队 3 个任务时处理器数为 %d，应扩容到 3", n)
+	}
+	if st := pool.scaleStats(); st.Queued != 3 || st.LastAction != "scale_up" {
+		t.Fatalf("伸缩记录为 %+v", st)
+	}
+	checkPool(t, pool, tracker)
+}
+
+// TestTrimIdle 关闭自动伸缩时，空闲超过 idle_timeout 的处理器仍会关闭，最少保留 min_processors 个
+func TestTrimIdle(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors: 1,
+		MaxProcessors: 4,
+		IdleTimeout:   time.Hour,
+	})
+	pool, tracker := newTrackedPool(t, s)
+	size := func() int {
+		st := pool.stats()
+		return st.Active + st.Idle
+	}
+	if added := pool.scaleUp(3); added != 3 {
+		t.Fatalf("扩容了 %d 个处理器", added)
+	}
+
+	pool.trimIdle()
+	if n := size(); n != 4 {
+		t.Fatalf("未达到 idle_timeout 时处理器数为 %d，应为 4", n)
+	}
+	pool.idleTimeout = 0
+	pool.trimIdle()
+	if n := size(); n != 1 {
+		t.Fatalf("空闲超时后处理器数为 %d，应为 1", n)
+	}
+	checkPool(t, pool, tracker)
+	if open := tracker.open(); open != 1 {
+		t.Fatalf("仍有 %d 个引擎未关闭，应为 1", open)
+	}
+}
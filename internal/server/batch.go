@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"ocr-server/logger"
+	"strings"
+)
+
+// ocrBatchRequest 批量识别请求，items 中未指定的选项使用顶层的同名字段
+type ocrBatchRequest struct {
+	ocrRequest
+	Items []ocrRequest `json:"items"`
+}
+
+// batchItem 批量请求中一张图片的结果，index 为图片在请求中的位置
+type batchItem struct {
+	Index int `json:"index"`
+	ocrResponse
+}
+
+// batchResult 批量请求的结果，items 与请求中的图片顺序一致
+type batchResult struct {
+	Items     []batchItem `json:"items"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+}
+
+// handleBatch 批量识别。每张图片作为单独的任务进入队列，由处理器池并行处理，
+// 全部完成后按请求顺序返回结果；单张图片失败不影响其他图片，错误放在对应的结果中
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	client, err := s.authorize(r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	items, images, err := s.readBatch(w, r)
+	if err != nil {
+		logger.LogInfo("读取批量请求失败: %v", err)
+		s.writeError(w, err)
+		return
+	}
+
+	logger.LogInfo("收到批量 OCR 请求，共 %d 张图片，正在排队处理", len(items))
+	result := batchResult{Items: make([]batchItem, len(items))}
+	pending := make([]chan ocrResponse, len(items))
+	// 所有图片共享一个 admission_wait 的入队期限，wait 策略下整个请求最多等待一次，到期后剩余的图片返回 queue_full
+	admitCtx, cancel := context.WithTimeoutCause(r.Context(), s.config.AdmissionWait, errQueueFull)
+	defer cancel()
+	for i, req := range items {
+		lane, task, err := s.newTask(r.Context(), client, req, images[i])
+		if err == nil {
+			err = s.submit(admitCtx, lane, task)
+		}
+		if r.Context().Err() != nil {
+			logger.LogInfo("客户端 %s 已断开连接，取消批量 OCR 任务", client.name)
+			return
+		}
+		if err != nil {
+			result.Items[i] = batchItem{i, errorResponse(err)}
+			continue
+		}
+		pending[i] = task.Response
+	}
+	for i, response := range pending {
+		if response == nil {
+			continue
+		}
+		select {
+		case result.Items[i].ocrResponse = <-response:
+			result.Items[i].Index = i
+		case <-r.Context().Done():
+			// 已入队的任务会在出队或执行时发现请求已取消并中止
+			logger.LogInfo("客户端 %s 已断开连接，取消批量 OCR 任务", client.name)
+			return
+		}
+	}
+	for _, item := range result.Items {
+		if item.Code == "" {
+			result.Succeeded++
+			continue
+		}
+		result.Failed++
+		logger.LogInfo("客户端 %s 的批量任务第 %d 项失败: %s %s", client.name, item.Index, item.Code, item.Error)
+	}
+	logger.LogInfo("批量 OCR 请求完成，成功 %d，失败 %d", result.Succeeded, result.Failed)
+	s.writeResponse(w, ocrResponse{Data: result})
+}
+
+// readBatch 读取批量识别请求，返回各图片的识别选项和上传的图片，两者按请求中的顺序一一对应：
+//   - application/json（默认）：ocrBatchRequest，每项通过 image_path 或 image_base64 传递图片；
+//   - multipart/form-data：每个 image 或 file 字段为一张图片，其余字段为所有图片共用的选项。
+func (s *Server) readBatch(w http.ResponseWriter, r *http.Request) ([]ocrRequest, [][]byte, error) {
+	mediaType, err := s.openBody(w, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var items []ocrRequest
+	var images [][]byte
+	switch {
+	case mediaType == "multipart/form-data":
+		var req ocrRequest
+		if req, images, err = s.readMultipart(r, s.config.MaxBatchSize); err != nil {
+			return nil, nil, err
+		}
+		items = make([]ocrRequest, len(images))
+		for i := range items {
+			items[i] = req
+		}
+	case strings.HasPrefix(mediaType, "image/"):
+		return nil, nil, invalidRequest("批量请求需使用 JSON 或 multipart/form-data")
+	default:
+		var batch ocrBatchRequest
+		if err := decodeJSON(r.Body, &batch); err != nil {
+			return nil, nil, err
+		}
+		if batch.ImagePath != "" || batch.Base64Content != "" {
+			return nil, nil, invalidRequest("批量请求的图片需放在 items 中")
+		}
+		if s.config.MaxBatchSize > 0 && len(batch.Items) > s.config.MaxBatchSize {
+			return nil, nil, invalidRequest(fmt.Sprintf("每个请求最多包含 %d 张图片", s.config.MaxBatchSize))
+		}
+		items = batch.Items
+		for i := range items {
+			items[i].inherit(batch.ocrRequest)
+		}
+		images = make([][]byte, len(items))
+	}
+	if len(items) == 0 {
+		return nil, nil, invalidRequest("批量请求中没有图片")
+	}
+	return items, images, nil
+}
+
+// inherit 未指定的选项使用 defaults 中的值，model 和 lang 都未指定时才继承两者
+func (req *ocrRequest) inherit(defaults ocrRequest) {
+	if req.Engine == "" {
+		req.Engine = defaults.Engine
+	}
+	if req.Model == "" && req.Lang == "" {
+		req.Model, req.Lang = defaults.Model, defaults.Lang
+	}
+	if req.Priority == "" {
+		req.Priority = defaults.Priority
+	}
+	if req.ThresholdMode == nil {
+		req.ThresholdMode = defaults.ThresholdMode
+	}
+	if req.ThresholdValue == nil {
+		req.ThresholdValue = defaults.ThresholdValue
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"ocr-server/internal/config"
+	"ocr-server/logger"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errCircuitOpen 引擎失败率过高，熔断期间直接拒绝任务
+var errCircuitOpen = errors.New("OCR 引擎连续失败，已暂停处理")
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// circuitBreaker 处理器池的熔断器，统计最近 window 次引擎调用的结果：
+//   - closed：样本数达到 minRequests 且失败率达到 failureRate 时打开；
+//   - open：直接拒绝任务和重试，openTimeout 后进入 half_open；
+//   - half_open：只放行一个探测任务，成功则关闭，失败则重新打开。
+//
+// 只统计引擎本身的失败（识别出错、无法创建引擎），客户端取消和超时不计入。
+type circuitBreaker struct {
+	name        string
+	failureRate int // 打开熔断的失败率（%），0 表示不启用
+	minRequests int
+	openTimeout time.Duration
+
+	mutex        sync.Mutex
+	state        string
+	outcomes     []bool // 最近的调用结果，true 表示失败，按环形缓冲区写入
+	next         int
+	failures     int
+	openedAt     time.Time
+	probeStarted time.Time // half_open 下探测任务的放行时间，探测未返回结果时 openTimeout 后再放行一个
+
+	opened   int64 // 累计打开次数，原子访问
+	rejected int64 // 熔断期间拒绝的任务数，原子访问
+}
+
+func newCircuitBreaker(name string, cfg config.Config) *circuitBreaker {
+	return &circuitBreaker{
+		name:        name,
+		failureRate: cfg.BreakerFailureRate,
+		minRequests: max(cfg.BreakerMinRequests, 1),
+		openTimeout: cfg.BreakerOpenTimeout,
+		state:       breakerClosed,
+		outcomes:    make([]bool, 0, max(cfg.BreakerWindow, cfg.BreakerMinRequests, 1)),
+	}
+}
+
+// allow 任务开始前调用，熔断打开时返回 errCircuitOpen
+func (b *circuitBreaker) allow() error {
+	if b.failureRate == 0 {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			break
+		}
+		b.state = breakerHalfOpen
+		logger.LogInfo("[%s] 熔断器进入半开状态，放行探测任务", b.name)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probeStarted.IsZero() || time.Since(b.probeStarted) >= b.openTimeout {
+			b.probeStarted = time.Now()
+			return nil
+		}
+	default:
+		return nil
+	}
+	atomic.AddInt64(&b.rejected, 1)
+	return errCircuitOpen
+}
+
+// isOpen 熔断是否处于打开状态，重试前检查，避免对已判定故障的引擎继续重试
+func (b *circuitBreaker) isOpen() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state == breakerOpen
+}
+
+// record 记录一次引擎调用的结果
+func (b *circuitBreaker) record(failed bool) {
+	if b.failureRate == 0 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.open()
+		} else {
+			logger.LogInfo("[%s] 探测任务成功，熔断器关闭", b.name)
+			b.reset(breakerClosed)
+		}
+		return
+	case breakerOpen:
+		// 打开前已开始的调用，结果不再计入
+		return
+	}
+
+	if len(b.outcomes) < cap(b.outcomes) {
+		b.outcomes = append(b.outcomes, failed)
+	} else {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+		b.outcomes[b.next] = failed
+		b.next = (b.next + 1) % len(b.outcomes)
+	}
+	if failed {
+		b.failures++
+	}
+	if len(b.outcomes) >= b.minRequests && b.failures*100 >= b.failureRate*len(b.outcomes) {
+		b.open()
+	}
+}
+
+// open 打开熔断，调用方需持有 mutex
+func (b *circuitBreaker) open() {
+	logger.LogError("[%s] 引擎失败率过高，熔断 %v", b.name, b.openTimeout)
+	b.reset(breakerOpen)
+	b.openedAt = time.Now()
+	atomic.AddInt64(&b.opened, 1)
+}
+
+// reset 切换状态并清空统计窗口，调用方需持有 mutex
+func (b *circuitBreaker) reset(state string) {
+	b.state = state
+	b.outcomes = b.outcomes[:0]
+	b.next = 0
+	b.failures = 0
+	b.probeStarted = time.Time{}
+}
+
+// retryIn 距离熔断进入半开状态的时间
+func (b *circuitBreaker) retryIn() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state != breakerOpen {
+		return b.openTimeout
+	}
+	return max(b.openTimeout-time.Since(b.openedAt), time.Second)
+}
+
+// breakerStats 熔断器的统计信息
+type breakerStats struct {
+	State       string    `json:"state"`
+	FailureRate float64   `json:"failure_rate"` // 统计窗口内的失败率（%）
+	Samples     int       `json:"samples"`
+	Opened      int64     `json:"opened"`
+	Rejected    int64     `json:"rejected"`
+	OpenedAt    time.Time `json:"opened_at,omitempty"`
+}
+
+func (b *circuitBreaker) stats() breakerStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	st := breakerStats{
+		State:    b.state,
+		Samples:  len(b.outcomes),
+		Opened:   atomic.LoadInt64(&b.opened),
+		Rejected: atomic.LoadInt64(&b.rejected),
+		OpenedAt: b.openedAt,
+	}
+	if b.failureRate == 0 {
+		st.State = "disabled"
+	}
+	if len(b.outcomes) > 0 {
+		st.FailureRate = float64(b.failures) * 100 / float64(len(b.outcomes))
+	}
+	return st
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"errors"
+	"ocr-server/internal/config"
+	"testing"
+	"time"
+)
+
+// TestCircuitBreaker 失败率达到阈值时打开熔断，open_timeout 后只放行一个探测任务，探测成功后关闭
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker("test", config.Config{
+		BreakerFailureRate: 50,
+		BreakerMinRequests: 4,
+		BreakerWindow:      4,
+		BreakerOpenTimeout: 20 * time.Millisecond,
+	})
+	for _, failed := range []bool{true, false, true} {
+		b.record(failed)
+	}
+	if b.isOpen() {
+		t.Fatal("样本数未达到 min_requests 时不应熔断")
+	}
+	b.record(false)
+	if !b.isOpen() {
+		t.Fatalf("失败率 50%% 时应熔断: %+v", b.stats())
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("熔断期间 allow 返回 %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("open_timeout 后应放行探测任务: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatal("半开状态只放行一个探测任务")
+	}
+	b.record(false)
+	if st := b.stats(); st.State != breakerClosed || st.Opened != 1 || st.Rejected != 2 {
+		t.Fatalf("探测成功后熔断器状态为 %+v", st)
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("熔断关闭后 allow 返回 %v", err)
+	}
+}
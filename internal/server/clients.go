@@ -0,0 +1,237 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"ocr-server/internal/config"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	anonymousClient    = "anonymous" // 请求未携带任何客户端标识时使用的名称
+	maxDynamicClients  = 10000       // 按请求头动态创建的客户端上限，超过后归入 anonymousClient
+	headerClientPrefix = "header:"   // 按请求头动态创建的客户端的名称前缀，与 clients 中配置的名称分开
+)
+
+var (
+	// errAPIKeyRequired 配置了 require_api_key，但请求没有有效的 API Key
+	errAPIKeyRequired = errors.New("缺少有效的 API Key")
+	// errQuotaExceeded 客户端当天的请求数已达到配额
+	errQuotaExceeded = errors.New("已超出每日配额")
+)
+
+// clientState 一个客户端的限制和计数
+type clientState struct {
+	name          string
+	weight        int   // 同一队列中轮到该客户端时连续调度的任务数
+	maxConcurrent int64 // 同时执行的任务数上限，0 表示不限制
+	dailyQuota    int64 // 每天接受的请求数上限，0 表示不限制
+
+	inFlight   int64 // 已出队、尚未完成的任务数，原子访问
+	dispatched int64 // 原子访问
+	rejected   int64 // 因配额被拒绝的请求数，原子访问
+
+	quotaLock sync.Mutex
+	day       string // 配额计数对应的日期
+	used      int64
+}
+
+func newClientState(name string, limits config.ClientLimits) *clientState {
+	return &clientState{
+		name:          name,
+		weight:        max(limits.Weight, 1),
+		maxConcurrent: int64(limits.MaxConcurrent),
+		dailyQuota:    limits.DailyQuota,
+	}
+}
+
+// canDispatch 客户端是否还能再执行一个任务
+func (c *clientState) canDispatch() bool {
+	return c.maxConcurrent == 0 || atomic.LoadInt64(&c.inFlight) < c.maxConcurrent
+}
+
+// admit 按本地日期计数，超出每日配额时返回 errQuotaExceeded
+func (c *clientState) admit() error {
+	c.quotaLock.Lock()
+	defer c.quotaLock.Unlock()
+	if err := c.checkQuotaLocked(); err != nil {
+		return err
+	}
+	c.used++
+	return nil
+}
+
+// checkQuota 当天的配额已用完时返回 errQuotaExceeded，不计入配额。
+// 在读取请求体之前调用，配额用完的客户端不必上传图片；入队时仍由 admit 计数
+func (c *clientState) checkQuota() error {
+	c.quotaLock.Lock()
+	defer c.quotaLock.Unlock()
+	return c.checkQuotaLocked()
+}
+
+func (c *clientState) checkQuotaLocked() error {
+	today := time.Now().Format("2006-01-02")
+	if c.day != today {
+		c.day = today
+		c.used = 0
+	}
+	if c.dailyQuota > 0 && c.used >= c.dailyQuota {
+		atomic.AddInt64(&c.rejected, 1)
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// quotaResetIn 距离每日配额重置（本地时间零点）的时间
+func quotaResetIn() time.Duration {
+	now := time.Now()
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Sub(now)
+}
+
+// refund 归还 admit 计入的一次配额，用于请求最终未入队的情况
+func (c *clientState) refund() {
+	c.quotaLock.Lock()
+	defer c.quotaLock.Unlock()
+	if c.used > 0 {
+		c.used--
+	}
+}
+
+// clientRegistry 根据 API Key 或请求头识别客户端
+type clientRegistry struct {
+	header   string // 未配置 API Key 的客户端用该请求头标识自己
+	require  bool   // 只接受携带已配置 API Key 的请求
+	defaults config.ClientLimits
+	byKey    map[string]*clientState
+	byHeader map[string]*clientState // clients 中未配置 API Key 的客户端，按请求头的值匹配
+
+	mutex   sync.Mutex
+	clients map[string]*clientState
+	dynamic int
+}
+
+func newClientRegistry(cfg config.Config) *clientRegistry {
+	cr := &clientRegistry{
+		header:   cfg.ClientHeader,
+		require:  cfg.RequireAPIKey,
+		defaults: cfg.DefaultClient,
+		byKey:    make(map[string]*clientState),
+		byHeader: make(map[string]*clientState),
+		clients:  make(map[string]*clientState),
+	}
+	for _, cc := range cfg.Clients {
+		c := newClientState(cc.Name, cc.ClientLimits)
+		cr.clients[cc.Name] = c
+		if cc.APIKey != "" {
+			cr.byKey[cc.APIKey] = c
+		} else {
+			cr.byHeader[cc.Name] = c
+		}
+	}
+	return cr
+}
+
+// apiKey 从 X-API-Key 或 Authorization: Bearer 中读取 API Key
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return ""
+}
+
+// identify 识别请求的客户端：已配置的 API Key 优先，其次是 client_header 请求头，最后是 anonymous。
+// 请求头只能匹配 clients 中未配置 API Key 的客户端，其他值使用带 headerClientPrefix 前缀的动态客户端，
+// 因此不能通过伪造请求头冒用配置了 API Key 的客户端的配额、并发名额和异步任务
+func (cr *clientRegistry) identify(r *http.Request) (*clientState, error) {
+	if key := apiKey(r); key != "" {
+		if c, ok := cr.byKey[key]; ok {
+			return c, nil
+		}
+		return nil, errAPIKeyRequired
+	}
+	if cr.require {
+		return nil, errAPIKeyRequired
+	}
+	name := anonymousClient
+	if cr.header != "" {
+		if v := strings.TrimSpace(r.Header.Get(cr.header)); v != "" && len(v) <= 64 {
+			if c, ok := cr.byHeader[v]; ok {
+				return c, nil
+			}
+			name = headerClientPrefix + v
+		}
+	}
+	return cr.get(name), nil
+}
+
+// get 返回指定名称的客户端，不存在时按默认限制创建
+func (cr *clientRegistry) get(name string) *clientState {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if c, ok := cr.clients[name]; ok {
+		return c
+	}
+	if cr.dynamic >= maxDynamicClients {
+		name = anonymousClient
+		if c, ok := cr.clients[name]; ok {
+			return c
+		}
+	}
+	c := newClientState(name, cr.defaults)
+	cr.clients[name] = c
+	cr.dynamic++
+	return c
+}
+
+// clientStats 一个客户端的统计信息
+type clientStats struct {
+	Queued        int   `json:"queued"`
+	InFlight      int64 `json:"in_flight"`
+	MaxConcurrent int64 `json:"max_concurrent,omitempty"`
+	Dispatched    int64 `json:"dispatched"`
+	QuotaUsed     int64 `json:"quota_used_today"`
+	DailyQuota    int64 `json:"daily_quota,omitempty"`
+	Rejected      int64 `json:"quota_rejected"`
+}
+
+func (cr *clientRegistry) stats(queued map[*clientState]int) map[string]clientStats {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	today := time.Now().Format("2006-01-02")
+	st := make(map[string]clientStats, len(cr.clients))
+	for name, c := range cr.clients {
+		c.quotaLock.Lock()
+		used := c.used
+		if c.day != today {
+			used = 0
+		}
+		c.quotaLock.Unlock()
+		st[name] = clientStats{
+			Queued:        queued[c],
+			InFlight:      atomic.LoadInt64(&c.inFlight),
+			MaxConcurrent: c.maxConcurrent,
+			Dispatched:    atomic.LoadInt64(&c.dispatched),
+			QuotaUsed:     used,
+			DailyQuota:    c.dailyQuota,
+			Rejected:      atomic.LoadInt64(&c.rejected),
+		}
+	}
+	return st
+}
+
+// remoteHost 返回请求来源的主机地址，用于日志
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
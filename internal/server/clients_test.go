@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"ocr-server/internal/config"
+	"testing"
+)
+
+// TestIdentify 请求头不能冒用配置了 API Key 的客户端
+func TestIdentify(t *testing.T) {
+	cr := newClientRegistry(config.Config{
+		ClientHeader:  "X-Client-ID",
+		DefaultClient: config.ClientLimits{DailyQuota: 100},
+		Clients: []config.ClientConfig{
+			{Name: "alice", APIKey: "alice-key", ClientLimits: config.ClientLimits{DailyQuota: 1}},
+			{Name: "internal", ClientLimits: config.ClientLimits{Weight: 3}},
+		},
+	})
+	identify := func(headers map[string]string) (*clientState, error) {
+		r := httptest.NewRequest("POST", "/ocr", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return cr.identify(r)
+	}
+
+	alice, err := identify(map[string]string{"X-API-Key": "alice-key"})
+	if err != nil || alice.name != "alice" {
+		t.Fatalf("API Key 识别为 %v, %v", alice, err)
+	}
+	if c, _ := identify(map[string]string{"Authorization": "Bearer alice-key"}); c != alice {
+		t.Fatal("Bearer 应识别为 alice")
+	}
+	if _, err := identify(map[string]string{"X-API-Key": "wrong"}); !errors.Is(err, errAPIKeyRequired) {
+		t.Fatalf("无效的 API Key 返回 %v", err)
+	}
+
+	spoofed, err := identify(map[string]string{"X-Client-ID": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spoofed == alice || spoofed.name != headerClientPrefix+"alice" || spoofed.dailyQuota != 100 {
+		t.Fatalf("请求头 alice 识别为 %s（配额 %d），不应是配置了 API Key 的 alice", spoofed.name, spoofed.dailyQuota)
+	}
+	// 冒用者的请求不计入 alice 的配额
+	if err := spoofed.admit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.admit(); err != nil {
+		t.Fatalf("alice 的配额被请求头客户端占用: %v", err)
+	}
+
+	if c, _ := identify(map[string]string{"X-Client-ID": "internal"}); c.name != "internal" || c.weight != 3 {
+		t.Fatalf("未配置 API Key 的客户端应按请求头匹配，实际为 %s", c.name)
+	}
+	if c, _ := identify(map[string]string{"X-Client-ID": headerClientPrefix + "alice"}); c == spoofed || c == alice {
+		t.Fatal("带前缀的请求头不应匹配已有的动态客户端")
+	}
+	if c, _ := identify(nil); c.name != anonymousClient {
+		t.Fatalf("没有标识的请求识别为 %s", c.name)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errEngineLimit 所有处理器池的引擎实例数已达到 max_engines，且其他池没有可以让出的空闲处理器
+var errEngineLimit = errors.New("引擎实例数已达上限")
+
+// engineLimiter 限制所有处理器池的引擎实例总数。每个处理器从创建到被丢弃占用一个名额，
+// 替换引擎时沿用原来的名额
+type engineLimiter struct {
+	mutex sync.Mutex
+	count int
+	max   int                              // 0 表示不限制
+	evict func(except *processorPool) bool // 名额用完时关闭其他池中的一个空闲处理器，返回是否关闭了
+}
+
+// engineStats 引擎实例数，出现在 /stats 中
+type engineStats struct {
+	Count int `json:"count"`
+	Max   int `json:"max"`
+}
+
+func newEngineLimiter(maxEngines int, evict func(except *processorPool) bool) *engineLimiter {
+	return &engineLimiter{max: maxEngines, evict: evict}
+}
+
+func (l *engineLimiter) tryReserve() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.max > 0 && l.count >= l.max {
+		return false
+	}
+	l.count++
+	return true
+}
+
+// reserve 为池 p 新建的处理器占用一个名额；名额用完时先让其他池关闭空闲最久的处理器，仍然没有名额时返回 errEngineLimit
+func (l *engineLimiter) reserve(p *processorPool) error {
+	if l.tryReserve() {
+		return nil
+	}
+	if l.evict != nil && l.evict(p) && l.tryReserve() {
+		return nil
+	}
+	return errEngineLimit
+}
+
+// release 归还一个名额
+func (l *engineLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.count--
+}
+
+func (l *engineLimiter) stats() engineStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return engineStats{Count: l.count, Max: l.max}
+}
+
+// evictIdle 关闭 except 以外的池中空闲最久的一个空闲处理器，为 except 腾出引擎名额
+func (s *Server) evictIdle(except *processorPool) bool {
+	var victim *processorPool
+	var longest time.Duration
+	for _, pool := range s.allPools() {
+		if pool == except {
+			continue
+		}
+		if idle, ok := pool.longestIdle(); ok && (victim == nil || idle > longest) {
+			victim, longest = pool, idle
+		}
+	}
+	return victim != nil && victim.evictIdle()
+}
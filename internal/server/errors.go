@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// 返回给客户端的机器可读错误码，见 ocrResponse.Code
+const (
+	codeInvalidRequest   = "invalid_request"    // 请求参数错误
+	codeMethodNotAllowed = "method_not_allowed" // 不支持的请求方法
+	codeBadInput         = "bad_input"          // 图像无法读取或解码，重试无效
+	codePayloadTooLarge  = "payload_too_large"  // 请求体超过 max_upload_mb
+	codeUnauthorized     = "unauthorized"       // 缺少或无效的 API Key
+	codeQuotaExceeded    = "quota_exceeded"     // 超出客户端每日配额
+	codeQueueFull        = "queue_full"         // 队列已满
+	codeShed             = "shed"               // 被更高优先级的任务挤出队列
+	codeBusy             = "busy"               // 等待处理器超时或引擎实例数已达上限
+	codeCircuitOpen      = "circuit_open"       // 引擎熔断中
+	codeShuttingDown     = "shutting_down"      // 服务器正在关闭
+	codeCancelled        = "cancelled"          // 客户端断开或取消了任务
+	codeTimeout          = "timeout"            // 任务超过 task_timeout
+	codeEngineError      = "engine_error"       // 引擎崩溃或通信失败，已重试
+	codeEngineFailure    = "engine_failure"     // 引擎正常返回但报告识别失败
+	codeNoText           = "no_text"            // 图像中没有识别到文字
+)
+
+// statusClientClosedRequest 客户端取消请求时的状态码，沿用 nginx 的 499
+const statusClientClosedRequest = 499
+
+// ocrError 带错误码和 HTTP 状态码的错误
+type ocrError struct {
+	code   string
+	status int
+	err    error
+}
+
+func (e *ocrError) Error() string { return e.err.Error() }
+func (e *ocrError) Unwrap() error { return e.err }
+
+// invalidRequest 请求参数错误
+func invalidRequest(message string) error {
+	return &ocrError{codeInvalidRequest, http.StatusBadRequest, errors.New(message)}
+}
+
+// badInput 图像数据有问题，任务不重试
+func badInput(err error) error {
+	return &ocrError{codeBadInput, http.StatusBadRequest, err}
+}
+
+// engineError 引擎调用失败，可以替换引擎后重试
+func engineError(err error) error {
+	return &ocrError{codeEngineError, http.StatusBadGateway, err}
+}
+
+// engineFailure 引擎通过 result.Code 报告的失败，同一张图像重试结果不变
+func engineFailure(result paddleocr.Result) error {
+	if result.Code == paddleocr.CodeNoText {
+		return &ocrError{codeNoText, http.StatusUnprocessableEntity, fmt.Errorf("OCR 失败: %s", result.Msg)}
+	}
+	return &ocrError{codeEngineFailure, http.StatusUnprocessableEntity, fmt.Errorf("OCR 失败（%d）: %s", result.Code, result.Msg)}
+}
+
+// classify 将错误归类为错误码、HTTP 状态码和返回给客户端的信息，超时、繁忙等使用固定文案
+func classify(err error) *ocrError {
+	var oe *ocrError
+	switch {
+	case errors.As(err, &oe):
+		return oe
+	case errors.Is(err, context.DeadlineExceeded):
+		return &ocrError{codeTimeout, http.StatusGatewayTimeout, errors.New("OCR 任务超时")}
+	case errors.Is(err, errAcquireTimeout):
+		return &ocrError{codeBusy, http.StatusServiceUnavailable, errors.New("服务器繁忙，等待处理器超时")}
+	case errors.Is(err, errEngineLimit):
+		return &ocrError{codeBusy, http.StatusServiceUnavailable, errors.New("服务器繁忙，引擎实例数已达上限")}
+	case errors.Is(err, errCircuitOpen):
+		return &ocrError{codeCircuitOpen, http.StatusServiceUnavailable, errors.New(errCircuitOpen.Error() + "，请稍后再试")}
+	case errors.Is(err, errShed):
+		return &ocrError{codeShed, http.StatusServiceUnavailable, errShed}
+	case errors.Is(err, errQueueFull):
+		return &ocrError{codeQueueFull, http.StatusServiceUnavailable, errors.New("服务器繁忙，请稍后再试")}
+	case errors.Is(err, errQuotaExceeded):
+		return &ocrError{codeQuotaExceeded, http.StatusTooManyRequests, errQuotaExceeded}
+	case errors.Is(err, errAPIKeyRequired):
+		return &ocrError{codeUnauthorized, http.StatusUnauthorized, errAPIKeyRequired}
+	case errors.Is(err, errShuttingDown), errors.Is(err, errPoolClosed):
+		return &ocrError{codeShuttingDown, http.StatusServiceUnavailable, errShuttingDown}
+	case errors.Is(err, context.Canceled):
+		return &ocrError{codeCancelled, statusClientClosedRequest, errors.New("任务已取消")}
+	default:
+		return &ocrError{codeEngineError, http.StatusBadGateway, err}
+	}
+}
+
+// errorResponse 将任务错误转换为响应
+func errorResponse(err error) ocrResponse {
+	oe := classify(err)
+	return ocrResponse{Error: oe.Error(), Code: oe.code, status: oe.status}
+}
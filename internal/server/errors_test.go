@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// TestClassify 错误码和状态码按错误链分类，客户端取消与服务器关闭分开
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{fmt.Errorf("执行 OCR 失败: %w", context.DeadlineExceeded), codeTimeout, http.StatusGatewayTimeout},
+		{errAcquireTimeout, codeBusy, http.StatusServiceUnavailable},
+		{errEngineLimit, codeBusy, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: 引擎崩溃", errCircuitOpen), codeCircuitOpen, http.StatusServiceUnavailable},
+		{errShed, codeShed, http.StatusServiceUnavailable},
+		{errQueueFull, codeQueueFull, http.StatusServiceUnavailable},
+		{errQuotaExceeded, codeQuotaExceeded, http.StatusTooManyRequests},
+		{errShuttingDown, codeShuttingDown, http.StatusServiceUnavailable},
+		{errPoolClosed, codeShuttingDown, http.StatusServiceUnavailable},
+		{context.Canceled, codeCancelled, statusClientClosedRequest},
+		{badInput(fmt.Errorf("无法解码图像")), codeBadInput, http.StatusBadRequest},
+		{fmt.Errorf("管道已关闭"), codeEngineError, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		oe := classify(tt.err)
+		if oe.code != tt.code || oe.status != tt.status {
+			t.Errorf("%v 分类为 %s（%d），应为 %s（%d）", tt.err, oe.code, oe.status, tt.code, tt.status)
+		}
+	}
+}
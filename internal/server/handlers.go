@@ -1,89 +1,182 @@
 package server
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"ocr-server/internal/imgproc"
 	"ocr-server/internal/utils"
 	"ocr-server/logger"
+	"ocr-server/pkg/ocrengine"
 	"time"
 )
 
 type ocrRequest struct {
+	Engine        string `json:"engine,omitempty"`   // 指定引擎，为空时使用默认引擎
+	Model         string `json:"model,omitempty"`    // 指定配置中的命名模型
+	Lang          string `json:"lang,omitempty"`     // 指定识别语言，与 model 二选一
+	Priority      string `json:"priority,omitempty"` // 优先级队列名称，为空时使用 default_priority
 	ImagePath     string `json:"image_path,omitempty"`
 	Base64Content string `json:"image_base64,omitempty"`
+
+	ThresholdMode  *int `json:"threshold_mode,omitempty"`  // 二值化阈值模式，为空时使用配置中的 threshold_mode
+	ThresholdValue *int `json:"threshold_value,omitempty"` // 二值化阈值，为空时使用配置中的 threshold_value
 }
 
 type ocrResponse struct {
 	Data  interface{} `json:"data,omitempty"`
 	Error string      `json:"error,omitempty"`
+	Code  string      `json:"code,omitempty"` // 机器可读的错误码，见 errors.go
+
+	status     int           // 非 0 时作为 HTTP 状态码返回
+	retryAfter time.Duration // status 为 503 时的 Retry-After，0 表示按吞吐量估算
+}
+
+// writeResponse 以 JSON 返回结果，错误响应使用其中的 HTTP 状态码
+func (s *Server) writeResponse(w http.ResponseWriter, response ocrResponse) {
+	s.backpressure(w, response)
+	w.Header().Set("Content-Type", "application/json")
+	if response.status != 0 {
+		w.WriteHeader(response.status)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeError 按错误分类返回错误码和 HTTP 状态码
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	s.writeResponse(w, errorResponse(err))
+}
+
+// authorize 在读取请求体之前识别客户端并检查当天的配额，未授权或配额已用完的请求不读取上传的图片
+func (s *Server) authorize(r *http.Request) (*clientState, error) {
+	client, err := s.clients.identify(r)
+	if err != nil {
+		logger.LogInfo("拒绝未授权的请求: %s", remoteHost(r))
+		return nil, err
+	}
+	if err := client.checkQuota(); err != nil {
+		logger.LogInfo("客户端 %s 已超出每日配额", client.name)
+		return nil, err
+	}
+	return client, nil
 }
 
 func (s *Server) handleOCR(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/stats" {
-		logger.LogInfo("收到获取服务器状态的请求")
-		stats := s.GetStats()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(stats)
+	client, err := s.authorize(r)
+	if err != nil {
+		s.writeError(w, err)
 		return
 	}
-
-	if r.Method != http.MethodPost {
-		logger.LogInfo("收到不支持的请求方法: %s", r.Method)
-		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	req, image, err := s.readRequest(w, r)
+	if err != nil {
+		logger.LogInfo("读取请求失败: %v", err)
+		s.writeError(w, err)
+		return
+	}
+	lane, task, err := s.newTask(r.Context(), client, req, image)
+	if err != nil {
+		s.writeError(w, err)
 		return
 	}
 
-	var req ocrRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.LogInfo("解析 JSON 失败: %v", err)
-		http.Error(w, "解析 JSON 失败", http.StatusBadRequest)
+	logger.LogInfo("收到 OCR 请求，正在排队处理")
+	if err := s.submit(r.Context(), lane, task); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	var response ocrResponse
+	select {
+	case response = <-task.Response:
+	case <-r.Context().Done():
+		// 任务会在出队或执行时发现请求已取消并中止
+		logger.LogInfo("客户端 %s 已断开连接，取消 OCR 任务", client.name)
 		return
 	}
+	if response.Code != "" {
+		logger.LogInfo("客户端 %s 的任务失败: %s %s", client.name, response.Code, response.Error)
+	}
+	s.writeResponse(w, response)
+}
+
+// newTask 校验识别请求并创建任务，image 为上传的图片，为 nil 时从 image_path 或 image_base64 读取。
+// ctx 结束时任务随之取消
+func (s *Server) newTask(ctx context.Context, client *clientState, req ocrRequest, image []byte) (*lane, ocrTask, error) {
+	var task ocrTask
+	if req.Engine != "" && !ocrengine.Registered(req.Engine) {
+		logger.LogError("请求的引擎不存在: %s", req.Engine)
+		return nil, task, invalidRequest("不支持的 OCR 引擎")
+	}
+	lane, err := s.lanes.lane(req.Priority)
+	if err != nil {
+		logger.LogError("请求的优先级不存在: %s", req.Priority)
+		return nil, task, invalidRequest("不支持的优先级")
+	}
+	model, err := s.resolveModel(req.Engine, req.Model, req.Lang)
+	if err != nil {
+		logger.LogError("请求的模型无效: %v", err)
+		return nil, task, invalidRequest(err.Error())
+	}
+	thresholdMode, thresholdValue := s.config.ThresholdMode, s.config.ThresholdValue
+	if req.ThresholdMode != nil {
+		if *req.ThresholdMode != int(imgproc.ThreshBinary) && *req.ThresholdMode != int(imgproc.ThreshOtsu) {
+			return nil, task, invalidRequest("threshold_mode 只能为 0 或 1")
+		}
+		thresholdMode = *req.ThresholdMode
+	}
+	if req.ThresholdValue != nil {
+		if *req.ThresholdValue < 0 || *req.ThresholdValue > 255 {
+			return nil, task, invalidRequest("threshold_value 必须在 0-255 之间")
+		}
+		thresholdValue = *req.ThresholdValue
+	}
 	if req.ImagePath != "" {
 		_, err := utils.DetectImageFormat(req.ImagePath)
 		if err != nil {
 			logger.LogError("请求参数非法！: %v", err)
-			http.Error(w, "图片上传格式错误", http.StatusNotAcceptable)
-			return
+			return nil, task, badInput(errors.New("图片上传格式错误"))
 		}
 	}
-	if req.Base64Content != "" && !utils.IsBase64Image(req.Base64Content) {
-		logger.LogError("请求参数非法！")
-		http.Error(w, "base64图片格式错误", http.StatusNotAcceptable)
-		return
-
+	if image == nil && req.Base64Content != "" {
+		image, _, err = utils.DecodeBase64Image(req.Base64Content)
+		if err != nil {
+			logger.LogInfo("无效的 base64 图像数据: %v", err)
+			return nil, task, badInput(err)
+		}
 	}
-	if req.ImagePath == "" && req.Base64Content == "" {
+	if req.ImagePath == "" && req.Base64Content == "" && len(image) == 0 {
 		logger.LogInfo("收到缺少图像数据的请求")
-		http.Error(w, "缺少 image_path 或 image_base64 参数", http.StatusNotAcceptable)
-		return
+		return nil, task, invalidRequest("缺少图片：需要 image_path、image_base64、上传的 image 字段或 image/* 请求体")
 	}
 
-	logger.LogInfo("收到 OCR 请求，正在排队处理")
-	task := ocrTask{
+	task = ocrTask{
+		Engine:    req.Engine,
+		Model:     model,
+		Priority:  lane.name,
+		Client:    client,
+		Ctx:       ctx,
 		ImagePath: req.ImagePath,
+		ImageData: image,
 		Response:  make(chan ocrResponse, 1),
-	}
 
-	if req.Base64Content != "" {
-		imageData, err := base64.StdEncoding.DecodeString(req.Base64Content)
-		if err != nil {
-			logger.LogInfo("无效的 base64 图像数据: %v", err)
-			http.Error(w, "无效的 base64 图像数据", http.StatusBadRequest)
-			return
-		}
-		task.ImageData = imageData
+		ThresholdMode:  thresholdMode,
+		ThresholdValue: thresholdValue,
 	}
+	return lane, task, nil
+}
 
-	select {
-	case s.taskQueue <- task:
-		logger.LogInfo("任务队列处理器已启动")
-		response := <-task.Response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-	case <-time.After(10 * time.Second):
-		logger.LogInfo("任务队列已满，请求超时")
-		http.Error(w, "服务器繁忙，请稍后再试", http.StatusServiceUnavailable)
+// submit 扣除客户端配额后将任务放入队列，入队失败时退还配额
+func (s *Server) submit(ctx context.Context, lane *lane, task ocrTask) error {
+	task.pool = s.getPool(task.Engine, task.Model)
+	client := task.Client
+	if err := client.admit(); err != nil {
+		logger.LogInfo("客户端 %s 已超出每日配额", client.name)
+		return err
+	}
+	if err := s.lanes.enqueue(ctx, lane, task); err != nil {
+		client.refund()
+		logger.LogInfo("任务入队失败（%s）: %v", lane.name, err)
+		return err
 	}
+	return nil
 }
@@ -0,0 +1,480 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"ocr-server/internal/config"
+	"ocr-server/pkg/ocrengine"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// startTestServer 启动使用 fake 引擎的服务器和任务队列，返回 HTTP 测试服务器，测试结束时按关闭流程停止
+func startTestServer(t *testing.T, cfg config.Config) (*Server, *httptest.Server) {
+	t.Helper()
+	s := newTestServer(t, cfg)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("初始化服务器失败: %v", err)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	s.wg.Add(1)
+	go s.processQueue(ctx)
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(func() {
+		ts.Close()
+		cancel(errShuttingDown)
+		s.wg.Wait()
+	})
+	return s, ts
+}
+
+// fakeText fake 引擎对所有图像返回的文本
+const fakeText = "离线识别"
+
+func fakeConfig() config.Config {
+	return config.Config{
+		MinProcessors: 1,
+		MaxProcessors: 2,
+		TaskTimeout:   5 * time.Second,
+		FakeEngine: ocrengine.FakeOptions{
+			Responses: map[string][]paddleocr.Data{
+				ocrengine.FakeWildcard: {{Rect: [][]int{{0, 0}, {8, 0}, {8, 8}, {0, 8}}, Score: 0.99, Text: fakeText}},
+			},
+		},
+	}
+}
+
+// testResponse 接口返回的 JSON，data 按需再解析
+type testResponse struct {
+	Data  json.RawMessage `json:"data"`
+	Error string          `json:"error"`
+	Code  string          `json:"code"`
+}
+
+func doRequest(t *testing.T, req *http.Request) (int, testResponse) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	var body testResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	return resp.StatusCode, body
+}
+
+func newRequest(t *testing.T, method, url, contentType string, body []byte) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("创建请求失败: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+// TestHandleOCR 通过 HTTP 接口、队列和处理器池完成识别，覆盖 JSON、multipart 和原始图片三种上传方式以及错误响应
+func TestHandleOCR(t *testing.T) {
+	_, ts := startTestServer(t, fakeConfig())
+	image := testImage(t)
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("image", "test.png")
+	part.Write(image)
+	mw.WriteField("priority", "high")
+	mw.Close()
+
+	jsonBody := func(v interface{}) []byte {
+		data, _ := json.Marshal(v)
+		return data
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        []byte
+		status      int
+		code        string
+	}{
+		{"JSON base64", http.MethodPost, "/v1/ocr", "application/json",
+			jsonBody(ocrRequest{Base64Content: base64.StdEncoding.EncodeToString(image)}), http.StatusOK, ""},
+		{"旧版接口", http.MethodPost, "/ocr", "",
+			jsonBody(ocrRequest{Base64Content: base64.StdEncoding.EncodeToString(image)}), http.StatusOK, ""},
+		{"旧版根路径", http.MethodPost, "/", "",
+			jsonBody(ocrRequest{Priority: "low", Base64Content: base64.StdEncoding.EncodeToString(image)}), http.StatusOK, ""},
+		{"multipart 上传", http.MethodPost, "/v1/ocr", mw.FormDataContentType(), form.Bytes(), http.StatusOK, ""},
+		{"原始图片请求体", http.MethodPost, "/v1/ocr?priority=low", "image/png", image, http.StatusOK, ""},
+		{"无法解码的图像", http.MethodPost, "/v1/ocr", "image/png", []byte("not an image"), http.StatusBadRequest, codeBadInput},
+		{"图片路径不存在", http.MethodPost, "/v1/ocr", "application/json",
+			jsonBody(ocrRequest{ImagePath: "/nonexistent/test.png"}), http.StatusBadRequest, codeBadInput},
+		{"无效的 base64", http.MethodPost, "/v1/ocr", "application/json",
+			jsonBody(ocrRequest{Base64Content: "!!!"}), http.StatusBadRequest, codeBadInput},
+		{"缺少图片", http.MethodPost, "/v1/ocr", "application/json", []byte(`{}`), http.StatusBadRequest, codeInvalidRequest},
+		{"不存在的优先级", http.MethodPost, "/v1/ocr", "application/json",
+			jsonBody(ocrRequest{Priority: "urgent", Base64Content: base64.StdEncoding.EncodeToString(image)}), http.StatusBadRequest, codeInvalidRequest},
+		{"不支持的方法", http.MethodGet, "/v1/ocr", "", nil, http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"不存在的路径", http.MethodGet, "/v2/ocr", "", nil, http.StatusNotFound, codeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := doRequest(t, newRequest(t, tt.method, ts.URL+tt.path, tt.contentType, tt.body))
+			if status != tt.status || body.Code != tt.code {
+				t.Fatalf("状态码 %d、错误码 %q，应为 %d、%q: %s", status, body.Code, tt.status, tt.code, body.Error)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var data []paddleocr.Data
+			if err := json.Unmarshal(body.Data, &data); err != nil {
+				t.Fatalf("解析识别结果失败: %v", err)
+			}
+			if len(data) != 1 || data[0].Text != fakeText {
+				t.Fatalf("识别结果为 %+v，应为 %q", data, fakeText)
+			}
+		})
+	}
+}
+
+// TestHandleOCRNoText 引擎没有识别到文字时返回 422 no_text
+func TestHandleOCRNoText(t *testing.T) {
+	cfg := fakeConfig()
+	cfg.FakeEngine.Responses = nil
+	_, ts := startTestServer(t, cfg)
+
+	status, body := doRequest(t, newRequest(t, http.MethodPost, ts.URL+"/v1/ocr", "image/png", testImage(t)))
+	if status != http.StatusUnprocessableEntity || body.Code != codeNoText {
+		t.Fatalf("状态码 %d、错误码 %q，应为 422、%q", status, body.Code, codeNoText)
+	}
+}
+
+// TestUploadTooLarge 请求体超过 max_upload_mb 时返回 413 payload_too_large
+func TestUploadTooLarge(t *testing.T) {
+	cfg := fakeConfig()
+	cfg.MaxUploadMB = 1
+	_, ts := startTestServer(t, cfg)
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("image", "large.png")
+	part.Write(make([]byte, 2<<20))
+	mw.Close()
+
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{"原始图片请求体", "image/png", make([]byte, 2<<20)},
+		{"multipart 上传", mw.FormDataContentType(), form.Bytes()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := doRequest(t, newRequest(t, http.MethodPost, ts.URL+"/v1/ocr", tt.contentType, tt.body))
+			if status != http.StatusRequestEntityTooLarge || body.Code != codePayloadTooLarge {
+				t.Fatalf("状态码 %d、错误码 %q，应为 413、%q", status, body.Code, codePayloadTooLarge)
+			}
+		})
+	}
+}
+
+// TestFakeResponseBySourceImage fake 引擎的预设结果按请求中的原始图像匹配，而不是二值化后交给引擎的图像
+func TestFakeResponseBySourceImage(t *testing.T) {
+	image := testImage(t)
+	cfg := fakeConfig()
+	cfg.FakeEngine.Responses = map[string][]paddleocr.Data{
+		ocrengine.ImageHash(image): {{Rect: [][]int{{0, 0}, {8, 0}, {8, 8}, {0, 8}}, Score: 0.9, Text: "原图"}},
+	}
+	_, ts := startTestServer(t, cfg)
+
+	status, body := doRequest(t, newRequest(t, http.MethodPost, ts.URL+"/v1/ocr", "image/png", image))
+	if status != http.StatusOK {
+		t.Fatalf("状态码 %d: %s", status, body.Error)
+	}
+	var data []paddleocr.Data
+	if err := json.Unmarshal(body.Data, &data); err != nil {
+		t.Fatalf("解析识别结果失败: %v", err)
+	}
+	if len(data) != 1 || data[0].Text != "原图" {
+		t.Fatalf("识别结果为 %+v，应匹配原始图像的预设结果", data)
+	}
+}
+
+// TestHandleJob 提交异步任务后轮询到识别结果，删除后不能再查询
+func TestHandleJob(t *testing.T) {
+	cfg := fakeConfig()
+	cfg.ClientHeader = "X-Client-ID"
+	_, ts := startTestServer(t, cfg)
+
+	req := newRequest(t, http.MethodPost, ts.URL+"/v1/jobs", "image/png", testImage(t))
+	req.Header.Set("X-Client-ID", "tester")
+	status, body := doRequest(t, req)
+	if status != http.StatusAccepted {
+		t.Fatalf("提交任务返回 %d: %s", status, body.Error)
+	}
+	var view jobView
+	if err := json.Unmarshal(body.Data, &view); err != nil {
+		t.Fatalf("解析任务状态失败: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for view.Status == jobQueued || view.Status == jobRunning {
+		if time.Now().After(deadline) {
+			t.Fatalf("任务未在期限内完成: %s", view.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		req := newRequest(t, http.MethodGet, ts.URL+"/v1/jobs/"+view.ID, "", nil)
+		req.Header.Set("X-Client-ID", "tester")
+		status, body = doRequest(t, req)
+		if status != http.StatusOK {
+			t.Fatalf("查询任务返回 %d: %s", status, body.Error)
+		}
+		view = jobView{}
+		if err := json.Unmarshal(body.Data, &view); err != nil {
+			t.Fatalf("解析任务状态失败: %v", err)
+		}
+	}
+	if view.Status != jobSucceeded {
+		t.Fatalf("任务状态为 %s，应为 %s", view.Status, jobSucceeded)
+	}
+	if result, _ := json.Marshal(view.Result); !strings.Contains(string(result), fakeText) {
+		t.Fatalf("任务结果为 %s，应包含 %q", result, fakeText)
+	}
+
+	// 其他客户端看不到该任务
+	other := newRequest(t, http.MethodGet, ts.URL+"/v1/jobs/"+view.ID, "", nil)
+	other.Header.Set("X-Client-ID", "someone-else")
+	if status, _ := doRequest(t, other); status != http.StatusNotFound {
+		t.Fatalf("其他客户端查询任务返回 %d，应为 404", status)
+	}
+
+	del := newRequest(t, http.MethodDelete, ts.URL+"/v1/jobs/"+view.ID, "", nil)
+	del.Header.Set("X-Client-ID", "tester")
+	if status, body := doRequest(t, del); status != http.StatusOK {
+		t.Fatalf("删除任务返回 %d: %s", status, body.Error)
+	}
+	get := newRequest(t, http.MethodGet, ts.URL+"/v1/jobs/"+view.ID, "", nil)
+	get.Header.Set("X-Client-ID", "tester")
+	if status, _ := doRequest(t, get); status != http.StatusNotFound {
+		t.Fatalf("删除后查询任务返回 %d，应为 404", status)
+	}
+}
+
+// TestHealthEndpoints /healthz 只要进程能响应就返回 200，/readyz 在收到关闭信号后返回 503
+func TestHealthEndpoints(t *testing.T) {
+	s, ts := startTestServer(t, fakeConfig())
+	get := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get("/healthz"); status != http.StatusOK {
+		t.Fatalf("/healthz 返回 %d", status)
+	}
+	if status := get("/readyz"); status != http.StatusOK {
+		t.Fatalf("/readyz 返回 %d", status)
+	}
+	s.stopping.Store(true)
+	if status := get("/readyz"); status != http.StatusServiceUnavailable {
+		t.Fatalf("关闭中 /readyz 返回 %d，应为 503", status)
+	}
+	if status := get("/healthz"); status != http.StatusOK {
+		t.Fatalf("关闭中 /healthz 返回 %d，应为 200", status)
+	}
+}
+
+// trackingBody 记录请求体是否被读取
+type trackingBody struct {
+	read bool
+}
+
+func (b *trackingBody) Read(p []byte) (int, error) {
+	b.read = true
+	return 0, io.EOF
+}
+
+func (b *trackingBody) Close() error { return nil }
+
+// TestAuthorizeBeforeBody 未授权或配额已用完的请求在读取请求体之前就被拒绝
+func TestAuthorizeBeforeBody(t *testing.T) {
+	cfg := fakeConfig()
+	cfg.RequireAPIKey = true
+	cfg.Clients = []config.ClientConfig{
+		{Name: "limited", APIKey: "limited-key", ClientLimits: config.ClientLimits{DailyQuota: 1}},
+	}
+	s := newTestServer(t, cfg)
+	handler := s.routes()
+	limited := s.clients.byKey["limited-key"]
+	if err := limited.admit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/v1/ocr", "/v1/ocr/batch", "/v1/jobs"} {
+		for _, tt := range []struct {
+			name   string
+			key    string
+			status int
+		}{
+			{"缺少 API Key", "", http.StatusUnauthorized},
+			{"无效的 API Key", "wrong", http.StatusUnauthorized},
+			{"配额已用完", "limited-key", http.StatusTooManyRequests},
+		} {
+			t.Run(path+" "+tt.name, func(t *testing.T) {
+				body := &trackingBody{}
+				req := httptest.NewRequest(http.MethodPost, path, nil)
+				req.Body = body
+				req.Header.Set("Content-Type", "image/png")
+				if tt.key != "" {
+					req.Header.Set("X-API-Key", tt.key)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != tt.status {
+					t.Fatalf("状态码 %d，应为 %d", rec.Code, tt.status)
+				}
+				if body.read {
+					t.Fatal("拒绝请求之前读取了请求体")
+				}
+			})
+		}
+	}
+}
+
+// TestHandleBatch 批量请求按顺序返回每张图片的结果，单张图片失败不影响其他图片，图片数超过 max_batch_size 时整个请求返回 400
+func TestHandleBatch(t *testing.T) {
+	cfg := fakeConfig()
+	cfg.MaxBatchSize = 2
+	_, ts := startTestServer(t, cfg)
+	encoded := base64.StdEncoding.EncodeToString(testImage(t))
+
+	post := func(batch ocrBatchRequest) (int, testResponse) {
+		data, _ := json.Marshal(batch)
+		return doRequest(t, newRequest(t, http.MethodPost, ts.URL+"/v1/ocr/batch", "application/json", data))
+	}
+	status, body := post(ocrBatchRequest{Items: []ocrRequest{{Base64Content: encoded}, {Base64Content: "!!!"}}})
+	if status != http.StatusOK {
+		t.Fatalf("批量请求返回 %d: %s", status, body.Error)
+	}
+	var result batchResult
+	if err := json.Unmarshal(body.Data, &result); err != nil {
+		t.Fatalf("解析批量结果失败: %v", err)
+	}
+	if result.Succeeded != 1 || result.Failed != 1 || len(result.Items) != 2 {
+		t.Fatalf("批量结果为 %+v，应成功 1 张、失败 1 张", result)
+	}
+	if item := result.Items[0]; item.Index != 0 || item.Code != "" {
+		t.Fatalf("第 1 张图片的结果为 %+v，应识别成功", item)
+	}
+	if item := result.Items[1]; item.Index != 1 || item.Code != codeBadInput {
+		t.Fatalf("第 2 张图片的结果为 %+v，错误码应为 %q", item, codeBadInput)
+	}
+
+	items := []ocrRequest{{Base64Content: encoded}, {Base64Content: encoded}, {Base64Content: encoded}}
+	if status, body := post(ocrBatchRequest{Items: items}); status != http.StatusBadRequest || body.Code != codeInvalidRequest {
+		t.Fatalf("超过 max_batch_size 时返回 %d、%q，应为 400、%q", status, body.Code, codeInvalidRequest)
+	}
+}
+
+// TestBatchAdmissionWait wait 策略下批量请求中的各张图片共享一个 admission_wait，
+// 队列一直满时整个请求约等待一次 admission_wait，而不是每张图片各等一次
+func TestBatchAdmissionWait(t *testing.T) {
+	const wait = 200 * time.Millisecond
+	cfg := fakeConfig()
+	cfg.AdmissionPolicy = admissionWait
+	cfg.AdmissionWait = wait
+	cfg.QueueSize = 1
+	s := newTestServer(t, cfg) // 不启动 processQueue，队列不会被消费
+	l, _ := s.lanes.lane("")
+	fill(t, s.lanes, l, newClientState("other", config.ClientLimits{}), 1)
+
+	encoded := base64.StdEncoding.EncodeToString(testImage(t))
+	batch := ocrBatchRequest{}
+	for i := 0; i < 5; i++ {
+		batch.Items = append(batch.Items, ocrRequest{Base64Content: encoded})
+	}
+	data, _ := json.Marshal(batch)
+	req := httptest.NewRequest(http.MethodPost, "/v1/ocr/batch", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	s.routes().ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > 2*wait {
+		t.Fatalf("批量请求等待了 %v，应约为一次 admission_wait（%v）", elapsed, wait)
+	}
+
+	var body struct {
+		Data batchResult `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if body.Data.Failed != len(batch.Items) {
+		t.Fatalf("失败 %d 张，应全部失败", body.Data.Failed)
+	}
+	for _, item := range body.Data.Items {
+		if item.Code != codeQueueFull {
+			t.Fatalf("第 %d 张图片的错误码为 %q，应为 %q", item.Index, item.Code, codeQueueFull)
+		}
+	}
+	if st := s.lanes.stats()[l.name]; st.Rejected != int64(len(batch.Items)) {
+		t.Fatalf("拒绝计数为 %d，应为 %d", st.Rejected, len(batch.Items))
+	}
+}
+
+// TestJobLimit 保存的异步任务达到 max_jobs 时新任务返回 queue_full，过期的任务会腾出空位；job_ttl 为 0 时使用默认保留时间
+func TestJobLimit(t *testing.T) {
+	cfg := fakeConfig()
+	cfg.MaxJobs = 1
+	s := newTestServer(t, cfg) // 不启动 processQueue，任务一直排队
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+	if s.jobs.ttl != defaultJobTTL {
+		t.Fatalf("job_ttl 为 0 时保留时间为 %v，应为 %v", s.jobs.ttl, defaultJobTTL)
+	}
+
+	post := func() (int, testResponse) {
+		return doRequest(t, newRequest(t, http.MethodPost, ts.URL+"/v1/jobs", "image/png", testImage(t)))
+	}
+	status, body := post()
+	if status != http.StatusAccepted {
+		t.Fatalf("提交任务返回 %d: %s", status, body.Error)
+	}
+	if status, body := post(); status != http.StatusServiceUnavailable || body.Code != codeQueueFull {
+		t.Fatalf("任务数达到上限时返回 %d、%q，应为 503、%q", status, body.Code, codeQueueFull)
+	}
+	if n := s.lanes.length(); n != 1 {
+		t.Fatalf("队列中有 %d 个任务，被拒绝的任务不应入队", n)
+	}
+
+	// 完成并过期的任务不再占用名额
+	var view jobView
+	json.Unmarshal(body.Data, &view)
+	s.jobs.mutex.Lock()
+	j := s.jobs.jobs[view.ID]
+	s.jobs.mutex.Unlock()
+	s.jobs.finish(j, ocrResponse{Data: "done"})
+	s.jobs.mutex.Lock()
+	j.finished = time.Now().Add(-2 * defaultJobTTL)
+	s.jobs.mutex.Unlock()
+	if status, body := post(); status != http.StatusAccepted {
+		t.Fatalf("旧任务过期后提交返回 %d: %s", status, body.Error)
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"ocr-server/logger"
+	"ocr-server/pkg/ocrengine"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// probeImage 内置的健康检查样例图像，内容为 probeText
+//
+//go:embed probe.jpg
+var probeImage []byte
+
+const probeText = "混合精度NaN问题"
+
+const (
+	healthInterval    = 30 * time.Second // 健康检查的周期
+	healthConcurrency = 4                // 每个池同时检查的处理器数
+)
+
+// healthProbe 健康检查的样例图像和期望识别出的文本，text 为空时只要求识别成功
+type healthProbe struct {
+	image []byte
+	text  string
+}
+
+// healthProbe 返回池使用的样例：配置了 health_probe_image 时使用配置的图像和文本；
+// 否则中文 paddleocr 池使用内置图像并校验文本，其他语言的 paddleocr 池只要求识别成功，
+// 其他引擎只调用引擎自身的 HealthCheck
+func (s *Server) healthProbe(engine, model string) healthProbe {
+	if s.config.HealthProbeImage != "" {
+		image, err := os.ReadFile(s.config.HealthProbeImage)
+		if err == nil {
+			return healthProbe{image: image, text: s.config.HealthProbeText}
+		}
+		logger.LogError("读取健康检查图像失败，使用内置图像: %v", err)
+	}
+	if engine != ocrengine.DefaultEngine {
+		return healthProbe{}
+	}
+	lang := s.config.Paddle.Lang
+	if model != "" {
+		lang = s.config.Paddle.Merge(s.modelConfig(model).PaddleArgs).Lang
+	}
+	if lang == "" || lang == "chinese" {
+		return healthProbe{image: probeImage, text: probeText}
+	}
+	return healthProbe{image: probeImage}
+}
+
+// check 检查识别结果是否符合预期
+func (hp healthProbe) check(result paddleocr.Result) error {
+	if result.Code != paddleocr.CodeSuccess && result.Code != paddleocr.CodeNoText {
+		return fmt.Errorf("识别失败: %d %s", result.Code, result.Msg)
+	}
+	if hp.text == "" {
+		return nil
+	}
+	var text strings.Builder
+	for _, data := range result.Data {
+		text.WriteString(data.Text)
+	}
+	got := strings.Join(strings.Fields(text.String()), "")
+	if !strings.Contains(got, strings.Join(strings.Fields(hp.text), "")) {
+		return fmt.Errorf("识别结果与样例不符: %q", got)
+	}
+	return nil
+}
+
+// probeProcessor 检查单个处理器：先调用引擎的 HealthCheck，再识别样例图像，调用方需已独占处理器
+func (p *processorPool) probeProcessor(ctx context.Context, processor *OCRProcessor) error {
+	engine := processor.engine()
+	if err := engine.HealthCheck(); err != nil {
+		return err
+	}
+	if p.probe.image == nil {
+		return nil
+	}
+	if p.probeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.probeTimeout)
+		defer cancel()
+	}
+	result, err := engine.Recognize(ctx, p.probe.image)
+	if err != nil {
+		return err
+	}
+	return p.probe.check(result)
+}
+
+// healthCheck 检查未在使用的处理器，不持有池锁，正在处理任务的处理器跳过。
+// 最多同时检查 healthConcurrency 个处理器，每个处理器通过 checkout 移出空闲池并占用信号量，不会同时分配给任务，
+// 没有空闲信号量时留到下一次检查。未通过检查的处理器先替换引擎再检查一次，仍不通过则隔离，
+// 隔离的处理器在下一次检查时重试。ctx 结束后不再开始新的检查
+func (p *processorPool) healthCheck(ctx context.Context) {
+	p.poolLock.Lock()
+	candidates := make([]*OCRProcessor, 0, len(p.activeProcessors)+len(p.idleProcessors))
+	candidates = append(candidates, p.activeProcessors...)
+	candidates = append(candidates, p.idleProcessors...)
+	quarantined := p.quarantined
+	p.quarantined = nil
+	p.poolLock.Unlock()
+
+	probes := make(chan struct{}, healthConcurrency)
+	var wg sync.WaitGroup
+	run := func(check func()) {
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-probes
+				<-p.slots
+				wg.Done()
+			}()
+			check()
+		}()
+	}
+	for _, processor := range candidates {
+		probes <- struct{}{}
+		if ctx.Err() != nil || !p.checkout(processor) {
+			<-probes
+			continue
+		}
+		run(func() {
+			if err := p.probeProcessor(ctx, processor); err != nil {
+				logger.LogError("[%s] 处理器 %p 未通过健康检查：%v", p.name, processor, err)
+				p.remove(processor)
+				p.restore(ctx, processor)
+				return
+			}
+			p.putBack(processor)
+		})
+	}
+	for i, processor := range quarantined {
+		probes <- struct{}{}
+		if ctx.Err() == nil && p.reserveSlot() {
+			run(func() { p.restore(ctx, processor) })
+			continue
+		}
+		<-probes
+		// 没有空闲的信号量或正在关闭，剩余的处理器留在隔离区
+		p.poolLock.Lock()
+		if !p.closed {
+			p.quarantined = append(p.quarantined, quarantined[i:]...)
+		}
+		p.poolLock.Unlock()
+		break
+	}
+	wg.Wait()
+
+	p.poolLock.Lock()
+	logger.LogInfo("[%s] 健康检查完成。激活：%d，空闲：%d，隔离：%d",
+		p.name, len(p.activeProcessors), len(p.idleProcessors), len(p.quarantined))
+	p.poolLock.Unlock()
+}
+
+// healthLoop 定期检查所有池的处理器，与 monitorProcessors 分开运行，检查耗时较长时不会推迟其他维护任务
+func (s *Server) healthLoop(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, pool := range s.allPools() {
+				pool.healthCheck(ctx)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ready 池中是否有可用的处理器：有未隔离的处理器，或还能创建新的处理器
+func (p *processorPool) ready() error {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	if p.closed {
+		return fmt.Errorf("[%s] 处理器池已关闭", p.name)
+	}
+	if len(p.activeProcessors)+len(p.idleProcessors) == 0 && len(p.quarantined) >= p.maxProcessors {
+		return fmt.Errorf("[%s] 所有处理器都未通过健康检查", p.name)
+	}
+	return nil
+}
+
+// restore 为不健康的处理器替换引擎并重新检查，通过后放回空闲池，否则关闭引擎并隔离（最多保留 maxProcessors 个）。
+// 隔离的处理器保留引擎名额，超出隔离上限或池已关闭时丢弃；调用方需持有信号量
+func (p *processorPool) restore(ctx context.Context, processor *OCRProcessor) {
+	err := p.replaceEngine(processor)
+	if err == nil {
+		err = p.probeProcessor(ctx, processor)
+	}
+
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	if err == nil && !p.closed {
+		processor.inUse = false
+		p.idleProcessors = append(p.idleProcessors, processor)
+		logger.LogInfo("[%s] 处理器 %p 已恢复", p.name, processor)
+		return
+	}
+	if p.closed || len(p.quarantined) >= p.maxProcessors {
+		p.discard(processor)
+		return
+	}
+	processor.engine().Close()
+	processor.inUse = true
+	p.quarantined = append(p.quarantined, processor)
+	logger.LogError("[%s] 处理器 %p 已隔离：%v", p.name, processor, err)
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"ocr-server/logger"
+	"sync"
+	"time"
+)
+
+// 异步任务的状态
+const (
+	jobQueued    = "queued"    // 在队列中等待
+	jobRunning   = "running"   // 已出队，正在处理
+	jobSucceeded = "succeeded" // 识别成功，结果在 result 中
+	jobFailed    = "failed"    // 识别失败，错误在 error 和 code 中
+	jobCancelled = "cancelled" // 被 DELETE 取消
+)
+
+const (
+	defaultJobTTL  = time.Hour // job_ttl 为 0 时完成的任务保留的时间
+	defaultMaxJobs = 10000     // max_jobs 为 0 时最多保存的任务数
+)
+
+// errJobNotFound 任务不存在、已过期或属于其他客户端
+var errJobNotFound = &ocrError{codeNotFound, http.StatusNotFound, errors.New("任务不存在")}
+
+// errTooManyJobs 保存的异步任务数已达到 max_jobs，按队列已满返回 503
+var errTooManyJobs = fmt.Errorf("%w: 异步任务数已达上限", errQueueFull)
+
+// job 通过 /v1/jobs 提交的异步识别任务
+type job struct {
+	id       string
+	client   *clientState
+	cancel   context.CancelFunc // 取消任务的 context，排队中和执行中的任务都会中止
+	status   string
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	response ocrResponse
+}
+
+// jobView 返回给客户端的任务状态
+type jobView struct {
+	ID         string      `json:"id"`
+	Status     string      `json:"status"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time  `json:"expires_at,omitempty"` // 完成后结果保留到该时间
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Code       string      `json:"code,omitempty"`
+}
+
+// jobRegistry 保存异步任务的状态和结果，完成的任务保留 ttl 后删除，最多保存 max 个任务
+type jobRegistry struct {
+	mutex sync.Mutex
+	jobs  map[string]*job
+	ttl   time.Duration
+	max   int
+}
+
+func newJobRegistry(ttl time.Duration, max int) *jobRegistry {
+	if ttl <= 0 {
+		ttl = defaultJobTTL
+	}
+	if max <= 0 {
+		max = defaultMaxJobs
+	}
+	return &jobRegistry{jobs: make(map[string]*job), ttl: ttl, max: max}
+}
+
+// newJob 创建排队中的任务，任务在 add 之后才能被查询
+func newJob(client *clientState, cancel context.CancelFunc) *job {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &job{id: hex.EncodeToString(id), client: client, cancel: cancel, status: jobQueued, created: time.Now()}
+}
+
+// add 保存任务，任务数达到上限时先删除已过期的任务，仍然没有空位时返回 errTooManyJobs
+func (r *jobRegistry) add(j *job) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.jobs) >= r.max {
+		r.expireLocked(time.Now())
+		if len(r.jobs) >= r.max {
+			return errTooManyJobs
+		}
+	}
+	r.jobs[j.id] = j
+	return nil
+}
+
+// drop 删除入队失败的任务
+func (r *jobRegistry) drop(j *job) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.jobs, j.id)
+}
+
+// get 返回客户端的任务状态，其他客户端的任务视为不存在
+func (r *jobRegistry) get(id string, client *clientState) (jobView, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	j, ok := r.jobs[id]
+	if !ok || j.client != client || r.expired(j, time.Now()) {
+		return jobView{}, errJobNotFound
+	}
+	return r.view(j), nil
+}
+
+// remove 取消未完成的任务并删除任务记录，返回删除前的状态
+func (r *jobRegistry) remove(id string, client *clientState) (jobView, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	j, ok := r.jobs[id]
+	if !ok || j.client != client || r.expired(j, time.Now()) {
+		return jobView{}, errJobNotFound
+	}
+	delete(r.jobs, id)
+	if j.finished.IsZero() {
+		j.status, j.finished = jobCancelled, time.Now()
+		j.cancel()
+		logger.LogInfo("客户端 %s 取消了异步任务 %s", client.name, id)
+	}
+	view := r.view(j)
+	view.ExpiresAt = nil
+	return view, nil
+}
+
+// start 任务出队开始处理
+func (r *jobRegistry) start(j *job) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if j.status == jobQueued {
+		j.status, j.started = jobRunning, time.Now()
+	}
+}
+
+// finish 保存任务结果，已取消的任务不再更新
+func (r *jobRegistry) finish(j *job, response ocrResponse) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !j.finished.IsZero() {
+		return
+	}
+	j.status, j.finished, j.response = jobSucceeded, time.Now(), response
+	if response.Code != "" {
+		j.status = jobFailed
+	}
+}
+
+// wait 等待任务结果。任务被取消时 processTask 不返回结果，服务器关闭时排队中的任务不会再出队
+func (r *jobRegistry) wait(ctx context.Context, j *job, response <-chan ocrResponse, shutdown <-chan struct{}) {
+	defer j.cancel()
+	select {
+	case res := <-response:
+		r.finish(j, res)
+		logger.LogInfo("异步任务 %s 已完成: %s", j.id, res.Code)
+	case <-ctx.Done():
+	case <-shutdown:
+		r.finish(j, errorResponse(errShuttingDown))
+	}
+}
+
+// expire 删除保留时间已过的任务
+func (r *jobRegistry) expire() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expireLocked(time.Now())
+}
+
+func (r *jobRegistry) expireLocked(now time.Time) {
+	for id, j := range r.jobs {
+		if r.expired(j, now) {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+func (r *jobRegistry) expired(j *job, now time.Time) bool {
+	return !j.finished.IsZero() && now.Sub(j.finished) > r.ttl
+}
+
+func (r *jobRegistry) view(j *job) jobView {
+	v := jobView{
+		ID:        j.id,
+		Status:    j.status,
+		CreatedAt: j.created,
+		Result:    j.response.Data,
+		Error:     j.response.Error,
+		Code:      j.response.Code,
+	}
+	if !j.started.IsZero() {
+		v.StartedAt = &j.started
+	}
+	if !j.finished.IsZero() {
+		v.FinishedAt = &j.finished
+		expires := j.finished.Add(r.ttl)
+		v.ExpiresAt = &expires
+	}
+	return v
+}
+
+// stats 按状态统计当前保存的任务数
+func (r *jobRegistry) stats() map[string]int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	counts := map[string]int{jobQueued: 0, jobRunning: 0, jobSucceeded: 0, jobFailed: 0}
+	for _, j := range r.jobs {
+		counts[j.status]++
+	}
+	return counts
+}
+
+// handleCreateJob 提交异步识别任务，请求格式与 /v1/ocr 相同。任务入队后立即返回 202 和任务 ID，
+// 通过 GET /v1/jobs/{id} 查询结果
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	client, err := s.authorize(r)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	req, image, err := s.readRequest(w, r)
+	if err != nil {
+		logger.LogInfo("读取请求失败: %v", err)
+		s.writeError(w, err)
+		return
+	}
+	// 任务不随请求取消，只能通过 DELETE 取消
+	ctx, cancel := context.WithCancel(context.Background())
+	lane, task, err := s.newTask(ctx, client, req, image)
+	if err != nil {
+		cancel()
+		s.writeError(w, err)
+		return
+	}
+	j := newJob(client, cancel)
+	task.onStart = func() { s.jobs.start(j) }
+	// 先占用任务记录再入队，任务数已达上限时不会入队
+	if err := s.jobs.add(j); err != nil {
+		cancel()
+		logger.LogInfo("拒绝客户端 %s 的异步任务: %v", client.name, err)
+		s.writeError(w, err)
+		return
+	}
+	if err := s.submit(r.Context(), lane, task); err != nil {
+		s.jobs.drop(j)
+		cancel()
+		s.writeError(w, err)
+		return
+	}
+	go s.jobs.wait(ctx, j, task.Response, s.shutdownChan)
+
+	logger.LogInfo("客户端 %s 提交了异步任务 %s", client.name, j.id)
+	w.Header().Set("Location", "/v1/jobs/"+j.id)
+	view, _ := s.jobs.get(j.id, client)
+	s.writeResponse(w, ocrResponse{Data: view, status: http.StatusAccepted})
+}
+
+// handleJob GET 查询任务状态和结果，DELETE 取消未完成的任务并删除任务记录
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	client, err := s.clients.identify(r)
+	if err != nil {
+		logger.LogInfo("拒绝未授权的请求: %s", remoteHost(r))
+		s.writeError(w, err)
+		return
+	}
+	var view jobView
+	if r.Method == http.MethodDelete {
+		view, err = s.jobs.remove(r.PathValue("id"), client)
+	} else {
+		view, err = s.jobs.get(r.PathValue("id"), client)
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeResponse(w, ocrResponse{Data: view})
+}
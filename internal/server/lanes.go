@@ -0,0 +1,425 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"ocr-server/internal/config"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errUnknownPriority 请求指定的优先级不存在
+var errUnknownPriority = errors.New("未知的优先级")
+
+// errQueueFull 队列已满，按准入策略拒绝
+var errQueueFull = errors.New("任务队列已满")
+
+// errShed 排队中的任务被更高优先级的任务挤出
+var errShed = errors.New("任务被更高优先级的请求挤出队列")
+
+// 准入策略，决定队列已满时如何处理新任务
+const (
+	admissionReject = "reject" // 立即拒绝
+	admissionWait   = "wait"   // 最多等待 admission_wait
+	admissionShed   = "shed"   // 挤出更低优先级队列中最新的任务，没有可挤出的任务时立即拒绝
+)
+
+// defaultLanes 未配置 lanes 时使用的三条队列，容量均为 queue_size
+func defaultLanes(queueSize int) []config.LaneConfig {
+	return []config.LaneConfig{
+		{Name: "high", Weight: 6, QueueSize: queueSize},
+		{Name: "normal", Weight: 3, QueueSize: queueSize},
+		{Name: "low", Weight: 1, QueueSize: queueSize},
+	}
+}
+
+// lane 一条优先级队列。队列内按客户端分成多个先进先出的子队列，
+// 出队时按客户端权重轮询（每次轮到某个客户端时最多连续取 weight 个任务），
+// 已达到并发上限的客户端跳过，因此单个客户端提交的大量任务不会挡住其他客户端
+type lane struct {
+	name    string
+	weight  int
+	space   chan struct{} // 容量为 queue_size 的令牌，入队时占用、出队时归还到任务的 slot
+	current int           // 平滑加权轮询的当前权重，只在 dispatchLock 下访问
+
+	mutex  sync.Mutex
+	queues map[*clientState][]ocrTask
+	ring   []*clientState // 有排队任务的客户端，按轮询顺序
+	pos    int            // 当前轮到的客户端
+	served int            // 当前客户端本轮已取出的任务数
+	queued int            // 排队中的任务数，shed 策略下可能超过 queue_size
+
+	enqueued   int64 // 原子访问
+	dispatched int64
+	rejected   int64
+	shed       int64 // 被挤出的任务数
+	waitNanos  int64 // 已出队任务的累计排队时间
+}
+
+func newLane(lc config.LaneConfig) *lane {
+	return &lane{
+		name:   lc.Name,
+		weight: max(lc.Weight, 1),
+		space:  make(chan struct{}, max(lc.QueueSize, 1)),
+		queues: make(map[*clientState][]ocrTask),
+	}
+}
+
+func (l *lane) push(task ocrTask) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.queues[task.Client]) == 0 {
+		l.ring = append(l.ring, task.Client)
+	}
+	l.queues[task.Client] = append(l.queues[task.Client], task)
+	l.queued++
+}
+
+// evictNewest 移除队列中最新入队的任务
+func (l *lane) evictNewest() (ocrTask, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	victim := -1
+	var newest time.Time
+	for i, c := range l.ring {
+		queue := l.queues[c]
+		if at := queue[len(queue)-1].EnqueuedAt; victim < 0 || at.After(newest) {
+			victim, newest = i, at
+		}
+	}
+	if victim < 0 {
+		return ocrTask{}, false
+	}
+	c := l.ring[victim]
+	queue := l.queues[c]
+	task := queue[len(queue)-1]
+	queue[len(queue)-1] = ocrTask{}
+	if len(queue) == 1 {
+		delete(l.queues, c)
+		l.ring = append(l.ring[:victim], l.ring[victim+1:]...)
+		if victim < l.pos {
+			l.pos--
+		} else if victim == l.pos {
+			l.served = 0
+		}
+	} else {
+		l.queues[c] = queue[:len(queue)-1]
+	}
+	l.queued--
+	return task, true
+}
+
+// ready 是否有可以立即执行的任务，只看每个客户端排在最前面的任务
+func (l *lane) ready(dispatchable func(ocrTask) bool) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, c := range l.ring {
+		if dispatchable(l.queues[c][0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// pop 按客户端加权轮询取出一个可以执行的任务，并计入客户端和处理器池的执行中任务数
+func (l *lane) pop(dispatchable func(ocrTask) bool) (ocrTask, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for range l.ring {
+		if l.pos >= len(l.ring) {
+			l.pos, l.served = 0, 0
+		}
+		c := l.ring[l.pos]
+		if !dispatchable(l.queues[c][0]) {
+			l.pos, l.served = l.pos+1, 0
+			continue
+		}
+		queue := l.queues[c]
+		task := queue[0]
+		queue[0] = ocrTask{}
+		queue = queue[1:]
+		l.served++
+		if len(queue) == 0 {
+			delete(l.queues, c)
+			l.ring = append(l.ring[:l.pos], l.ring[l.pos+1:]...)
+			l.served = 0
+		} else {
+			l.queues[c] = queue
+			if l.served >= c.weight {
+				l.pos, l.served = l.pos+1, 0
+			}
+		}
+		l.queued--
+		atomic.AddInt64(&c.inFlight, 1)
+		atomic.AddInt64(&c.dispatched, 1)
+		if task.pool != nil {
+			atomic.AddInt64(&task.pool.dispatched, 1)
+		}
+		<-task.slot.space
+		return task, true
+	}
+	return ocrTask{}, false
+}
+
+// length 队列中的任务数
+func (l *lane) length() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.queued
+}
+
+// laneScheduler 多条加权队列，processQueue 按平滑加权轮询从有可执行任务的队列中取任务，
+// 批量任务占满低优先级队列时，高优先级队列仍按权重获得调度。
+// 任务只在客户端、处理器池和全局的执行名额都有空余时出队，处理器池已占满的任务留在队列中，
+// 不会占用名额等待处理器，其他池的任务仍按优先级调度
+type laneScheduler struct {
+	lanes        []*lane
+	policy       string        // 准入策略
+	wait         time.Duration // wait 策略下等待队列空位的最长时间
+	maxInFlight  int64         // 同时执行的任务数上限，0 表示只受各处理器池限制
+	inFlight     int64         // 已出队、尚未完成的任务数，原子访问
+	byName       map[string]*lane
+	defaultLane  *lane
+	notify       chan struct{} // 有任务入队或有客户端的任务完成时发送，容量为 1
+	dispatchLock sync.Mutex    // 串行化 next，保护各队列的 current
+}
+
+func newLaneScheduler(lanes []config.LaneConfig, defaultPriority, policy string, wait time.Duration, maxInFlight int) *laneScheduler {
+	ls := &laneScheduler{
+		policy:      policy,
+		wait:        wait,
+		maxInFlight: int64(maxInFlight),
+		byName:      make(map[string]*lane),
+		notify:      make(chan struct{}, 1),
+	}
+	for _, lc := range lanes {
+		l := newLane(lc)
+		ls.lanes = append(ls.lanes, l)
+		ls.byName[l.name] = l
+	}
+	ls.defaultLane = ls.byName[defaultPriority]
+	if ls.defaultLane == nil {
+		ls.defaultLane = ls.lanes[len(ls.lanes)/2]
+	}
+	return ls
+}
+
+// lane 按名称查找队列，名称为空时返回默认队列
+func (ls *laneScheduler) lane(priority string) (*lane, error) {
+	if priority == "" {
+		return ls.defaultLane, nil
+	}
+	l, ok := ls.byName[priority]
+	if !ok {
+		return nil, errUnknownPriority
+	}
+	return l, nil
+}
+
+// wake 通知 processQueue 重新检查队列
+func (ls *laneScheduler) wake() {
+	select {
+	case ls.notify <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue 将任务放入对应队列，队列已满时按准入策略等待、挤出低优先级任务或返回 errQueueFull
+func (ls *laneScheduler) enqueue(ctx context.Context, l *lane, task ocrTask) error {
+	task.EnqueuedAt = time.Now()
+	task.slot = l
+	select {
+	case l.space <- struct{}{}:
+	default:
+		slot, err := ls.admitFull(ctx, l)
+		if err != nil {
+			if errors.Is(err, errQueueFull) {
+				atomic.AddInt64(&l.rejected, 1)
+			}
+			return err
+		}
+		task.slot = slot
+	}
+	l.push(task)
+	atomic.AddInt64(&l.enqueued, 1)
+	ls.wake()
+	return nil
+}
+
+// admitFull 队列已满时按准入策略处理，返回新任务占用容量的队列。
+// wait 策略下 ctx 结束时返回 context.Cause(ctx)，调用方可以用 context.WithTimeoutCause 让多次入队共享同一个等待期限
+func (ls *laneScheduler) admitFull(ctx context.Context, l *lane) (*lane, error) {
+	switch ls.policy {
+	case admissionShed:
+		if slot := ls.shed(l); slot != nil {
+			return slot, nil
+		}
+		return nil, errQueueFull
+	case admissionWait:
+		if ls.wait <= 0 {
+			return nil, errQueueFull
+		}
+		timer := time.NewTimer(ls.wait)
+		defer timer.Stop()
+		select {
+		case l.space <- struct{}{}:
+			return l, nil
+		case <-timer.C:
+			return nil, errQueueFull
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	default:
+		return nil, errQueueFull
+	}
+}
+
+// shed 从权重低于 l 的队列中挤出最新入队的任务，先挤权重最低的队列；
+// 被挤出的任务收到 errShed，新任务接管它占用的容量。没有可挤出的任务时返回 nil
+func (ls *laneScheduler) shed(l *lane) *lane {
+	var candidates []*lane
+	for _, v := range ls.lanes {
+		if v.weight < l.weight {
+			candidates = append(candidates, v)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].weight < candidates[j].weight })
+	for _, v := range candidates {
+		victim, ok := v.evictNewest()
+		if !ok {
+			continue
+		}
+		atomic.AddInt64(&v.shed, 1)
+		victim.Client.refund()
+		victim.Response <- errorResponse(errShed)
+		return victim.slot
+	}
+	return nil
+}
+
+// dispatchable 任务是否可以出队：客户端、任务使用的处理器池和全局都还有执行名额。
+// 出队都在 dispatchLock 下进行，检查和计数之间不会有其他任务出队
+func (ls *laneScheduler) dispatchable(task ocrTask) bool {
+	if ls.maxInFlight > 0 && atomic.LoadInt64(&ls.inFlight) >= ls.maxInFlight {
+		return false
+	}
+	return task.Client.canDispatch() && (task.pool == nil || task.pool.canDispatch())
+}
+
+// next 按平滑加权轮询取出一个任务，没有可执行的任务时返回 false
+func (ls *laneScheduler) next() (ocrTask, bool) {
+	ls.dispatchLock.Lock()
+	defer ls.dispatchLock.Unlock()
+	for {
+		var best *lane
+		total := 0
+		for _, l := range ls.lanes {
+			if !l.ready(ls.dispatchable) {
+				continue
+			}
+			l.current += l.weight
+			total += l.weight
+			if best == nil || l.current > best.current {
+				best = l
+			}
+		}
+		if best == nil {
+			return ocrTask{}, false
+		}
+		best.current -= total
+		if task, ok := best.pop(ls.dispatchable); ok {
+			atomic.AddInt64(&ls.inFlight, 1)
+			atomic.AddInt64(&best.dispatched, 1)
+			atomic.AddInt64(&best.waitNanos, int64(time.Since(task.EnqueuedAt)))
+			return task, true
+		}
+		// 队列在检查后发生了变化，重新选择
+	}
+}
+
+// done 任务执行完成，客户端、处理器池和全局的执行名额释放后可能有新的任务可以执行
+func (ls *laneScheduler) done(task ocrTask) {
+	atomic.AddInt64(&task.Client.inFlight, -1)
+	if task.pool != nil {
+		atomic.AddInt64(&task.pool.dispatched, -1)
+	}
+	atomic.AddInt64(&ls.inFlight, -1)
+	ls.wake()
+}
+
+// length 所有队列中排队的任务数
+func (ls *laneScheduler) length() int {
+	n := 0
+	for _, l := range ls.lanes {
+		n += l.length()
+	}
+	return n
+}
+
+// queuedByClient 各客户端在所有队列中排队的任务数
+func (ls *laneScheduler) queuedByClient() map[*clientState]int {
+	queued := make(map[*clientState]int)
+	for _, l := range ls.lanes {
+		l.mutex.Lock()
+		for c, queue := range l.queues {
+			queued[c] += len(queue)
+		}
+		l.mutex.Unlock()
+	}
+	return queued
+}
+
+// queuedByPool 各处理器池在所有队列中排队的任务数，键为 poolName，任务未指定引擎时计入 defaultEngine
+func (ls *laneScheduler) queuedByPool(defaultEngine string) map[string]int {
+	queued := make(map[string]int)
+	for _, l := range ls.lanes {
+		l.mutex.Lock()
+		for _, queue := range l.queues {
+			for _, task := range queue {
+				engine := task.Engine
+				if engine == "" {
+					engine = defaultEngine
+				}
+				queued[poolName(engine, task.Model)]++
+			}
+		}
+		l.mutex.Unlock()
+	}
+	return queued
+}
+
+// laneStats 一条队列的统计信息
+type laneStats struct {
+	Weight         int     `json:"weight"`
+	QueueLength    int     `json:"queue_length"`
+	QueueSize      int     `json:"queue_size"`
+	Enqueued       int64   `json:"enqueued"`
+	Dispatched     int64   `json:"dispatched"`
+	Rejected       int64   `json:"rejected"`
+	Shed           int64   `json:"shed"`
+	AverageWaiting float64 `json:"average_wait_seconds"` // 已出队任务的平均排队时间
+}
+
+func (ls *laneScheduler) stats() map[string]laneStats {
+	st := make(map[string]laneStats, len(ls.lanes))
+	for _, l := range ls.lanes {
+		dispatched := atomic.LoadInt64(&l.dispatched)
+		avg := float64(0)
+		if dispatched > 0 {
+			avg = time.Duration(atomic.LoadInt64(&l.waitNanos) / dispatched).Seconds()
+		}
+		st[l.name] = laneStats{
+			Weight:         l.weight,
+			QueueLength:    l.length(),
+			QueueSize:      cap(l.space),
+			Enqueued:       atomic.LoadInt64(&l.enqueued),
+			Dispatched:     dispatched,
+			Rejected:       atomic.LoadInt64(&l.rejected),
+			Shed:           atomic.LoadInt64(&l.shed),
+			AverageWaiting: avg,
+		}
+	}
+	return st
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"ocr-server/internal/config"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fill 向队列放入 n 个属于 client 的任务，任务的 Priority 记录所在队列
+func fill(t *testing.T, ls *laneScheduler, l *lane, client *clientState, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := ls.enqueue(context.Background(), l, ocrTask{Priority: l.name, Client: client, Response: make(chan ocrResponse, 1)}); err != nil {
+			t.Fatalf("任务入队失败: %v", err)
+		}
+	}
+}
+
+// TestLaneWeights 三条队列都有任务时按 6:3:1 的权重出队，且每 10 个任务中高优先级队列都能获得调度
+func TestLaneWeights(t *testing.T) {
+	ls := newLaneScheduler(defaultLanes(100), "normal", admissionReject, 0, 0)
+	client := newClientState("bulk", config.ClientLimits{})
+	for _, name := range []string{"high", "normal", "low"} {
+		l, _ := ls.lane(name)
+		fill(t, ls, l, client, 100)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		task, ok := ls.next()
+		if !ok {
+			t.Fatal("队列中有任务时 next 返回 false")
+		}
+		counts[task.Priority]++
+		if i%10 == 9 && counts["high"] < 6*(i+1)/10 {
+			t.Fatalf("前 %d 个任务中 high 只有 %d 个", i+1, counts["high"])
+		}
+	}
+	if counts["high"] != 60 || counts["normal"] != 30 || counts["low"] != 10 {
+		t.Fatalf("出队数量为 %v，应为 high 60、normal 30、low 10", counts)
+	}
+}
+
+// TestLaneSkipsEmptyLanes 只有低优先级队列有任务时直接调度该队列
+func TestLaneSkipsEmptyLanes(t *testing.T) {
+	ls := newLaneScheduler(defaultLanes(10), "normal", admissionReject, 0, 0)
+	low, _ := ls.lane("low")
+	fill(t, ls, low, newClientState("bulk", config.ClientLimits{}), 3)
+	for i := 0; i < 3; i++ {
+		if task, ok := ls.next(); !ok || task.Priority != "low" {
+			t.Fatalf("第 %d 次出队失败", i+1)
+		}
+	}
+	if _, ok := ls.next(); ok {
+		t.Fatal("队列为空时 next 返回了任务")
+	}
+}
+
+// TestLaneClientFairness 同一队列中大量任务的客户端不会挡住后来的客户端，客户端按权重轮流出队
+func TestLaneClientFairness(t *testing.T) {
+	ls := newLaneScheduler(defaultLanes(100), "normal", admissionReject, 0, 0)
+	l, _ := ls.lane("normal")
+	bulk := newClientState("bulk", config.ClientLimits{})
+	vip := newClientState("vip", config.ClientLimits{Weight: 3})
+	small := newClientState("small", config.ClientLimits{})
+	fill(t, ls, l, bulk, 40)
+	fill(t, ls, l, vip, 30)
+	fill(t, ls, l, small, 2)
+
+	var order []string
+	for i := 0; i < 20; i++ {
+		task, ok := ls.next()
+		if !ok {
+			t.Fatal("队列中有任务时 next 返回 false")
+		}
+		order = append(order, task.Client.name)
+	}
+	// 每轮 bulk 连续出队 1 个、vip 3 个、small 1 个
+	want := []string{"bulk", "vip", "vip", "vip", "small", "bulk", "vip", "vip", "vip", "small"}
+	for i, name := range want {
+		if order[i] != name {
+			t.Fatalf("出队顺序为 %v，前 10 个应为 %v", order, want)
+		}
+	}
+	counts := make(map[string]int)
+	for _, name := range order {
+		counts[name]++
+	}
+	if counts["bulk"] != 5 || counts["vip"] != 13 {
+		t.Fatalf("前 20 个任务中 bulk %d 个、vip %d 个，应为 5 和 13", counts["bulk"], counts["vip"])
+	}
+}
+
+// TestLaneMaxConcurrent 达到并发上限的客户端被跳过，任务完成后恢复调度
+func TestLaneMaxConcurrent(t *testing.T) {
+	ls := newLaneScheduler(defaultLanes(10), "normal", admissionReject, 0, 0)
+	l, _ := ls.lane("normal")
+	limited := newClientState("limited", config.ClientLimits{MaxConcurrent: 1})
+	other := newClientState("other", config.ClientLimits{})
+	fill(t, ls, l, limited, 3)
+	fill(t, ls, l, other, 1)
+
+	first, _ := ls.next()
+	if first.Client != limited {
+		t.Fatalf("第一个任务属于 %s，应为 limited", first.Client.name)
+	}
+	if task, ok := ls.next(); !ok || task.Client != other {
+		t.Fatal("limited 达到并发上限时应调度 other 的任务")
+	}
+	if _, ok := ls.next(); ok {
+		t.Fatal("limited 达到并发上限时不应再调度它的任务")
+	}
+	ls.done(first)
+	if task, ok := ls.next(); !ok || task.Client != limited {
+		t.Fatal("limited 的任务完成后应恢复调度")
+	}
+}
+
+// TestLanePoolSaturated 某个处理器池的执行名额用完后，它的任务留在队列中，
+// 其他池的任务（包括更低优先级的）照常出队，池中的任务完成后恢复调度
+func TestLanePoolSaturated(t *testing.T) {
+	s := newTestServer(t, config.Config{MaxProcessors: 2})
+	client := newClientState("bulk", config.ClientLimits{})
+	submit := func(priority, model string) {
+		t.Helper()
+		l, _ := s.lanes.lane(priority)
+		task := ocrTask{Model: model, Client: client, Response: make(chan ocrResponse, 1)}
+		task.pool = s.getPool(task.Engine, task.Model)
+		if err := s.lanes.enqueue(context.Background(), l, task); err != nil {
+			t.Fatalf("任务入队失败: %v", err)
+		}
+	}
+
+	submit("high", "slow")
+	submit("high", "slow")
+	var running []ocrTask
+	for i := 0; i < 2; i++ {
+		task, ok := s.lanes.next()
+		if !ok || task.Model != "slow" {
+			t.Fatal("slow 池未占满时任务应出队")
+		}
+		running = append(running, task)
+	}
+	slow := s.getPool(s.config.Engine, "slow")
+	if st := slow.stats(); st.Dispatched != 2 {
+		t.Fatalf("slow 池执行中的任务数为 %d，应为 2", st.Dispatched)
+	}
+
+	submit("high", "slow")
+	submit("low", "")
+	task, ok := s.lanes.next()
+	if !ok || task.Model != "" {
+		t.Fatal("slow 池占满时应调度默认池的低优先级任务")
+	}
+	if _, ok := s.lanes.next(); ok {
+		t.Fatal("slow 池占满时不应再调度它的任务")
+	}
+	if st := s.lanes.stats()["high"]; st.QueueLength != 1 {
+		t.Fatalf("high 队列长度为 %d，等待 slow 池的任务应留在队列中", st.QueueLength)
+	}
+
+	s.lanes.done(running[0])
+	if task, ok := s.lanes.next(); !ok || task.Model != "slow" {
+		t.Fatal("slow 池的任务完成后应恢复调度")
+	}
+}
+
+// TestLaneMaxInFlight max_in_flight 限制所有池合计的执行中任务数
+func TestLaneMaxInFlight(t *testing.T) {
+	ls := newLaneScheduler(defaultLanes(10), "normal", admissionReject, 0, 1)
+	l, _ := ls.lane("normal")
+	fill(t, ls, l, newClientState("bulk", config.ClientLimits{}), 2)
+	first, ok := ls.next()
+	if !ok {
+		t.Fatal("队列中有任务时 next 返回 false")
+	}
+	if _, ok := ls.next(); ok {
+		t.Fatal("达到 max_in_flight 时不应再调度")
+	}
+	ls.done(first)
+	if _, ok := ls.next(); !ok {
+		t.Fatal("任务完成后应恢复调度")
+	}
+}
+
+// TestLaneShed shed 策略下队列已满时挤出低优先级队列中最新的任务，新任务接管它的容量；
+// 没有更低优先级的任务可挤出时拒绝
+func TestLaneShed(t *testing.T) {
+	ls := newLaneScheduler(defaultLanes(1), "normal", admissionShed, 0, 0)
+	client := newClientState("bulk", config.ClientLimits{})
+	high, _ := ls.lane("high")
+	low, _ := ls.lane("low")
+	victim := ocrTask{Priority: "low", Client: client, Response: make(chan ocrResponse, 1)}
+	if err := ls.enqueue(context.Background(), low, victim); err != nil {
+		t.Fatalf("任务入队失败: %v", err)
+	}
+	fill(t, ls, high, client, 1)
+
+	if err := ls.enqueue(context.Background(), high, ocrTask{Priority: "high", Client: client, Response: make(chan ocrResponse, 1)}); err != nil {
+		t.Fatalf("high 队列已满时应挤出 low 的任务: %v", err)
+	}
+	select {
+	case response := <-victim.Response:
+		if response.status != http.StatusServiceUnavailable {
+			t.Fatalf("被挤出的任务状态码为 %d", response.status)
+		}
+	default:
+		t.Fatal("被挤出的任务没有收到响应")
+	}
+	if st := ls.stats(); st["low"].Shed != 1 || st["low"].QueueLength != 0 || st["high"].QueueLength != 2 {
+		t.Fatalf("队列统计为 %+v", st)
+	}
+	if err := ls.enqueue(context.Background(), low, ocrTask{Priority: "low", Client: client, Response: make(chan ocrResponse, 1)}); !errors.Is(err, errQueueFull) {
+		t.Fatalf("low 队列的容量被占用时入队返回 %v，应为 errQueueFull", err)
+	}
+
+	// 挤出后 high 中的任务有一个占用的是 low 的容量，出队时归还给 low
+	for i := 0; i < 2; i++ {
+		if _, ok := ls.next(); !ok {
+			t.Fatal("队列中有任务时 next 返回 false")
+		}
+	}
+	if err := ls.enqueue(context.Background(), low, ocrTask{Priority: "low", Client: client, Response: make(chan ocrResponse, 1)}); err != nil {
+		t.Fatalf("容量归还后 low 入队失败: %v", err)
+	}
+}
+
+// TestHighPriorityNotStarved 只有一个处理器、低优先级队列堆积批量任务时，
+// 后提交的高优先级任务在少数几个批量任务之后就能完成
+func TestHighPriorityNotStarved(t *testing.T) {
+	cfg := fakeConfig()
+	cfg.MaxProcessors = 1
+	cfg.MaxInFlight = 1
+	cfg.QueueSize = 64
+	cfg.FakeEngine.Latency = 5 * time.Millisecond
+	s, _ := startTestServer(t, cfg)
+	image := testImage(t)
+
+	var finished atomic.Int64
+	submit := func(priority, client string) chan int64 {
+		lane, task, err := s.newTask(context.Background(), newClientState(client, config.ClientLimits{}), ocrRequest{Priority: priority}, image)
+		if err != nil {
+			t.Fatalf("创建任务失败: %v", err)
+		}
+		if err := s.submit(context.Background(), lane, task); err != nil {
+			t.Fatalf("提交任务失败: %v", err)
+		}
+		order := make(chan int64, 1)
+		go func() {
+			<-task.Response
+			order <- finished.Add(1)
+		}()
+		return order
+	}
+
+	const bulk = 40
+	var wg sync.WaitGroup
+	for i := 0; i < bulk; i++ {
+		order := submit("low", "batch")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-order
+		}()
+	}
+	high := <-submit("high", "interactive")
+	wg.Wait()
+	if high > 5 {
+		t.Fatalf("高优先级任务第 %d 个完成，排在 %d 个批量任务之后", high, high-1)
+	}
+}
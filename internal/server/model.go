@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"ocr-server/internal/config"
+	"ocr-server/pkg/ocrengine"
+	"regexp"
+	"slices"
+)
+
+// tesseractLangPattern tesseract 语言参数，例如 chi_sim+eng
+var tesseractLangPattern = regexp.MustCompile(`^[A-Za-z0-9_]+(\+[A-Za-z0-9_]+)*$`)
+
+// poolName 处理器池名称：引擎名，指定模型时为 引擎名/模型名
+func poolName(engine, model string) string {
+	if model == "" {
+		return engine
+	}
+	return engine + "/" + model
+}
+
+// resolveModel 将请求中的 model / lang 解析为处理器池使用的模型名，空字符串表示引擎的默认配置。
+// model 必须是配置中的命名模型；lang 优先匹配同名的命名模型，与引擎默认语言相同时使用默认池，
+// 否则必须在 allowed_langs 中，作为该引擎的语言参数。每个模型对应一个处理器池，因此池的数量不会超过配置的模型和语言数
+func (s *Server) resolveModel(engine, model, lang string) (string, error) {
+	if engine == "" {
+		engine = s.config.Engine
+	}
+	if model != "" && lang != "" {
+		return "", fmt.Errorf("model 与 lang 只能指定一个")
+	}
+	if model != "" {
+		if engine != ocrengine.DefaultEngine {
+			return "", fmt.Errorf("引擎 %s 不支持 model 参数", engine)
+		}
+		if _, ok := s.config.Models[model]; !ok {
+			return "", fmt.Errorf("未配置的模型: %s", model)
+		}
+		return model, nil
+	}
+	if lang == "" {
+		return "", nil
+	}
+	if engine == ocrengine.DefaultEngine {
+		if _, ok := s.config.Models[lang]; ok {
+			return lang, nil
+		}
+	}
+	if lang == s.defaultLang(engine) {
+		return "", nil
+	}
+	if !slices.Contains(s.config.AllowedLangs, lang) {
+		return "", fmt.Errorf("未允许的语言: %s，可在 allowed_langs 中配置", lang)
+	}
+	switch engine {
+	case ocrengine.DefaultEngine:
+		if _, ok := ocrengine.PaddleLanguages[lang]; !ok {
+			return "", fmt.Errorf("不支持的语言: %s", lang)
+		}
+	case ocrengine.TesseractEngineName:
+		if !tesseractLangPattern.MatchString(lang) {
+			return "", fmt.Errorf("不支持的语言: %s", lang)
+		}
+	}
+	return lang, nil
+}
+
+// defaultLang 返回引擎默认配置使用的语言，未配置时为空
+func (s *Server) defaultLang(engine string) string {
+	switch engine {
+	case ocrengine.DefaultEngine:
+		return s.config.Paddle.Lang
+	case ocrengine.TesseractEngineName:
+		return s.config.Tesseract.Lang
+	}
+	return ""
+}
+
+// modelConfig 返回模型的配置；未在 models 中配置的语言按 paddle 配置覆盖 lang，处理器按需创建
+func (s *Server) modelConfig(model string) config.ModelConfig {
+	if mc, ok := s.config.Models[model]; ok {
+		return mc
+	}
+	return config.ModelConfig{PaddleArgs: ocrengine.PaddleArgs{Lang: model}}
+}
+
+// newEngine 按配置创建指定引擎和模型的引擎实例
+func (s *Server) newEngine(engine, model string) (ocrengine.Engine, error) {
+	opts := ocrengine.Options{
+		ExePath:   s.config.OCRExePath,
+		Install:   s.config.InstallOptions,
+		Paddle:    s.config.Paddle,
+		Fake:      s.config.FakeEngine,
+		Tesseract: s.config.Tesseract,
+	}
+	if model != "" {
+		switch engine {
+		case ocrengine.DefaultEngine:
+			opts.Paddle = opts.Paddle.Merge(s.modelConfig(model).PaddleArgs)
+		case ocrengine.TesseractEngineName:
+			opts.Tesseract.Lang = model
+		}
+	}
+	return ocrengine.New(engine, opts)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"ocr-server/internal/config"
+	"ocr-server/pkg/ocrengine"
+	"testing"
+)
+
+// TestResolveModel lang 只能选择 models 中的模型、引擎的默认语言或 allowed_langs 中的语言，池的数量因此有上限
+func TestResolveModel(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		Paddle:       ocrengine.PaddleArgs{Lang: "en"},
+		Models:       map[string]config.ModelConfig{"japan": {}, "invoice": {}},
+		AllowedLangs: []string{"korean", "klingon", "chi_sim+eng"},
+	})
+	paddle, tesseract := ocrengine.DefaultEngine, ocrengine.TesseractEngineName
+
+	tests := []struct {
+		name   string
+		engine string
+		model  string
+		lang   string
+		want   string
+		err    bool
+	}{
+		{"默认配置", paddle, "", "", "", false},
+		{"命名模型", paddle, "invoice", "", "invoice", false},
+		{"未配置的模型", paddle, "receipt", "", "", true},
+		{"同时指定 model 和 lang", paddle, "invoice", "korean", "", true},
+		{"与模型同名的语言", paddle, "", "japan", "japan", false},
+		{"默认语言使用默认池", paddle, "", "en", "", false},
+		{"允许的语言", paddle, "", "korean", "korean", false},
+		{"未允许的语言", paddle, "", "french_v2", "", true},
+		{"允许但引擎不支持的语言", paddle, "", "klingon", "", true},
+		{"tesseract 允许的语言", tesseract, "", "chi_sim+eng", "chi_sim+eng", false},
+		{"tesseract 未允许的语言组合", tesseract, "", "chi_sim+eng+jpn", "", true},
+		{"tesseract 不支持 model", tesseract, "invoice", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.resolveModel(tt.engine, tt.model, tt.lang)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("应返回错误，实际解析为 %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("模型为 %q，应为 %q", got, tt.want)
+			}
+		})
+	}
+}
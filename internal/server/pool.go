@@ -0,0 +1,495 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ocr-server/logger"
+	"ocr-server/pkg/ocrengine"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// processorPool 同一种引擎和模型的处理器池，各池独立伸缩
+type processorPool struct {
+	name             string // 池名称，见 poolName
+	engine           string // 引擎名称
+	model            string // 模型名称，为空表示引擎的默认配置
+	newEngine        func() (ocrengine.Engine, error)
+	engines          *engineLimiter // 所有池共享的引擎实例数限制
+	minProcessors    int
+	maxProcessors    int
+	warmUpCount      int
+	idleTimeout      time.Duration
+	activeProcessors []*OCRProcessor //活跃的处理器
+	idleProcessors   []*OCRProcessor
+	acquireTimeout   time.Duration // 等待处理器的超时时间，0 表示只受 context 限制
+	slots            chan struct{} // 信号量，容量为 maxProcessors
+	waiting          int64         // 正在 acquire 中等待的任务数，原子访问
+	dispatched       int64         // 已从队列出队、尚未完成的任务数，不超过 maxProcessors，原子访问
+	scaler           autoscaler    // 自动伸缩状态，见 autoscale.go
+	breaker          *circuitBreaker
+	recycleJobs      int64         // 引擎处理多少个任务后回收，0 表示不限制
+	recycleAge       time.Duration // 引擎运行多久后回收，0 表示不限制
+	recycleRSS       int64         // 引擎进程常驻内存超过多少字节后回收，0 表示不限制
+	closed           bool          // 池已关闭，后台创建的处理器不再加入
+	warming          int           // 正在创建的预热处理器数，受 poolLock 保护
+	totalJobs        int64         // 池累计处理的任务数，原子访问
+	probe            healthProbe   // 健康检查使用的样例图像
+	probeTimeout     time.Duration
+	quarantined      []*OCRProcessor // 未通过健康检查、已移出轮转的处理器，引擎已关闭
+	recycled         int64           // 池累计回收引擎的次数，原子访问
+	poolLock         sync.Mutex      // 保护 activeProcessors、idleProcessors 和处理器的 inUse
+}
+
+// canDispatch 池是否还能再接收一个出队的任务
+func (p *processorPool) canDispatch() bool {
+	return atomic.LoadInt64(&p.dispatched) < int64(p.maxProcessors)
+}
+
+// errAcquireTimeout 等待处理器超时
+var errAcquireTimeout = errors.New("等待可用处理器超时")
+
+// errPoolClosed 处理器池已关闭，不再创建引擎
+var errPoolClosed = errors.New("处理器池已关闭")
+
+func newProcessorPool(engine, model string, minProcessors, maxProcessors, warmUpCount int, s *Server) *processorPool {
+	p := &processorPool{
+		name:             poolName(engine, model),
+		engine:           engine,
+		model:            model,
+		newEngine:        func() (ocrengine.Engine, error) { return s.newEngine(engine, model) },
+		engines:          s.engines,
+		minProcessors:    minProcessors,
+		maxProcessors:    maxProcessors,
+		warmUpCount:      warmUpCount,
+		idleTimeout:      s.config.IdleTimeout,
+		acquireTimeout:   s.config.AcquireTimeout,
+		slots:            make(chan struct{}, maxProcessors),
+		scaler:           newAutoscaler(s.config),
+		breaker:          newCircuitBreaker(poolName(engine, model), s.config),
+		probe:            s.healthProbe(engine, model),
+		probeTimeout:     s.config.HealthProbeTimeout,
+		recycleJobs:      s.config.RecycleAfterJobs,
+		recycleAge:       s.config.RecycleAfterAge,
+		recycleRSS:       int64(s.config.RecycleRSSMB) << 20,
+		activeProcessors: make([]*OCRProcessor, 0, maxProcessors),
+		idleProcessors:   make([]*OCRProcessor, 0, maxProcessors),
+	}
+	return p
+}
+
+// createOCRProcessor 占用一个引擎名额并创建处理器，创建失败时归还名额
+func (p *processorPool) createOCRProcessor() (*OCRProcessor, error) {
+	if err := p.engines.reserve(p); err != nil {
+		return nil, err
+	}
+	engine, err := p.newEngine()
+	if err != nil {
+		p.engines.release()
+		return nil, err
+	}
+	return &OCRProcessor{
+		processor: engine,
+		lastUsed:  time.Now(),
+		startedAt: time.Now(),
+	}, nil
+}
+
+// replaceEngine 关闭处理器当前的引擎并替换为新创建的引擎，调用方需独占处理器。
+// 池已关闭时不再创建引擎；新引擎在持有池锁时换上，与 close 互斥，不会在关闭后遗留引擎进程
+func (p *processorPool) replaceEngine(processor *OCRProcessor) error {
+	processor.engine().Close()
+	p.poolLock.Lock()
+	closed := p.closed
+	p.poolLock.Unlock()
+	if closed {
+		return errPoolClosed
+	}
+	engine, err := p.newEngine()
+	if err != nil {
+		return err
+	}
+
+	p.poolLock.Lock()
+	closed = p.closed
+	if !closed {
+		processor.mutex.Lock()
+		processor.processor = engine
+		processor.lastUsed = time.Now()
+		processor.startedAt = time.Now()
+		processor.mutex.Unlock()
+	}
+	p.poolLock.Unlock()
+	if closed {
+		engine.Close()
+		return errPoolClosed
+	}
+	atomic.StoreInt64(&processor.jobs, 0)
+	return nil
+}
+
+// initialize 创建最小数量的激活处理器和预热处理器，创建引擎时不持有池锁
+func (p *processorPool) initialize() error {
+	for i := 0; i < p.minProcessors; i++ {
+		processor, err := p.createOCRProcessor()
+		if err != nil {
+			logger.LogInfo("[%s] 初始化处理器 %d 失败: %v", p.name, i, err)
+			return err
+		}
+		p.poolLock.Lock()
+		p.activeProcessors = append(p.activeProcessors, processor)
+		p.poolLock.Unlock()
+		logger.LogInfo("[%s] 处理器 %d 已初始化", p.name, i)
+	}
+
+	for i := 0; i < p.warmUpCount; i++ {
+		processor, err := p.createOCRProcessor()
+		if err != nil {
+			logger.LogInfo("[%s] 无法预热处理器 %d：%v", p.name, i, err)
+			continue
+		}
+		p.poolLock.Lock()
+		p.idleProcessors = append(p.idleProcessors, processor)
+		p.poolLock.Unlock()
+		logger.LogInfo("[%s] 预热处理器 %d 已创建", p.name, i)
+	}
+	return nil
+}
+
+// ensureMinProcessors 补足最小数量的激活处理器，创建引擎时不持有池锁，不阻塞正在处理的请求
+func (p *processorPool) ensureMinProcessors() {
+	for {
+		p.poolLock.Lock()
+		missing := len(p.activeProcessors) < p.minProcessors
+		p.poolLock.Unlock()
+		if !missing {
+			return
+		}
+		processor, err := p.createOCRProcessor()
+		if err != nil {
+			logger.LogError("[%s] 无法创建最小数量的处理器：%v", p.name, err)
+			return
+		}
+		p.poolLock.Lock()
+		if !p.closed && len(p.activeProcessors) < p.minProcessors {
+			p.activeProcessors = append(p.activeProcessors, processor)
+			processor = nil
+		}
+		p.poolLock.Unlock()
+		if processor != nil {
+			p.discard(processor)
+			return
+		}
+	}
+}
+
+// acquire 获取一个处理器：先占用信号量，保证同时使用的处理器不超过 maxProcessors，
+// 再选择空闲的激活处理器、启用预热处理器或创建新处理器。
+// ctx 取消或等待超过 acquireTimeout 时返回错误，成功时调用方必须调用 release
+func (p *processorPool) acquire(ctx context.Context) (*OCRProcessor, error) {
+	start := time.Now()
+	atomic.AddInt64(&p.waiting, 1)
+	defer func() {
+		atomic.AddInt64(&p.waiting, -1)
+		p.recordWait(time.Since(start))
+	}()
+
+	var timeout <-chan time.Time
+	if p.acquireTimeout > 0 {
+		timer := time.NewTimer(p.acquireTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	case <-timeout:
+		return nil, errAcquireTimeout
+	}
+
+	if processor := p.takeProcessor(); processor != nil {
+		return processor, nil
+	}
+	processor, err := p.createOCRProcessor()
+	if err != nil {
+		<-p.slots
+		if !errors.Is(err, errEngineLimit) {
+			p.breaker.record(true)
+		}
+		logger.LogError("[%s] 创建处理器失败: %v", p.name, err)
+		return nil, fmt.Errorf("创建处理器失败: %w", err)
+	}
+	p.poolLock.Lock()
+	closed := p.closed
+	if !closed {
+		processor.inUse = true
+		p.activeProcessors = append(p.activeProcessors, processor)
+	}
+	p.poolLock.Unlock()
+	if closed {
+		<-p.slots
+		p.discard(processor)
+		return nil, errPoolClosed
+	}
+	return processor, nil
+}
+
+// takeProcessor 在持有信号量的前提下取出一个已有的处理器，没有时返回 nil。
+// 空闲池中的处理器都未被占用，回收和健康检查会先通过 checkout 将处理器移出空闲池
+func (p *processorPool) takeProcessor() *OCRProcessor {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	for _, processor := range p.activeProcessors {
+		if !processor.inUse {
+			processor.inUse = true
+			return processor
+		}
+	}
+	if len(p.idleProcessors) > 0 {
+		processor := p.idleProcessors[len(p.idleProcessors)-1]
+		p.idleProcessors = p.idleProcessors[:len(p.idleProcessors)-1]
+		p.activeProcessors = append(p.activeProcessors, processor)
+		processor.inUse = true
+		return processor
+	}
+	return nil
+}
+
+// release 归还处理器并释放信号量，超过最小数量的处理器移入空闲池。
+// 需要回收的处理器在后台替换引擎，替换完成前保持占用状态和信号量，不会被分配给其他任务，
+// 也不会因为名额空出而另外创建处理器，池中的处理器数不超过 maxProcessors
+func (p *processorPool) release(processor *OCRProcessor) {
+	if reason := p.recycleReason(processor); reason != "" {
+		go func() {
+			p.recycle(processor, reason)
+			<-p.slots
+		}()
+		return
+	}
+	p.putBack(processor)
+	<-p.slots
+}
+
+// checkout 不等待地占用一个信号量，并将未被占用的处理器标记为使用中，空闲池中的处理器同时移出空闲池，
+// 供回收和健康检查独占使用，期间与任务一样计入 maxProcessors。
+// 没有空闲的信号量、处理器已被占用或已不在池中时返回 false；成功时调用方必须调用 putBack 或 remove，再调用 <-p.slots
+func (p *processorPool) checkout(processor *OCRProcessor) bool {
+	if !p.reserveSlot() {
+		return false
+	}
+	if p.take(processor) {
+		return true
+	}
+	<-p.slots
+	return false
+}
+
+// reserveSlot 不等待地占用一个信号量，成功时调用方必须调用 <-p.slots
+func (p *processorPool) reserveSlot() bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// take 将未被占用的处理器标记为使用中，空闲池中的处理器同时移出空闲池
+func (p *processorPool) take(processor *OCRProcessor) bool {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	if processor.inUse {
+		return false
+	}
+	for _, ap := range p.activeProcessors {
+		if ap == processor {
+			processor.inUse = true
+			return true
+		}
+	}
+	for i, ip := range p.idleProcessors {
+		if ip == processor {
+			p.idleProcessors = append(p.idleProcessors[:i], p.idleProcessors[i+1:]...)
+			processor.inUse = true
+			return true
+		}
+	}
+	return false
+}
+
+// putBack 将处理器标记为空闲，超过最小数量的激活处理器移入空闲池，checkout 移出的处理器放回池中；
+// 池已关闭时关闭处理器的引擎
+func (p *processorPool) putBack(processor *OCRProcessor) {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	processor.inUse = false
+	if p.closed {
+		p.discard(processor)
+		return
+	}
+
+	for i, ap := range p.activeProcessors {
+		if ap == processor {
+			if len(p.activeProcessors) > p.minProcessors {
+				p.activeProcessors = append(p.activeProcessors[:i], p.activeProcessors[i+1:]...)
+				p.idleProcessors = append(p.idleProcessors, processor)
+			}
+			return
+		}
+	}
+	if len(p.activeProcessors) < p.minProcessors {
+		p.activeProcessors = append(p.activeProcessors, processor)
+	} else {
+		p.idleProcessors = append(p.idleProcessors, processor)
+	}
+}
+
+// preWarmProcessors 补足预热的空闲处理器：在池锁内预留要创建的数量，创建引擎时不持有池锁，
+// 再加锁放入空闲池，池已关闭时丢弃
+func (p *processorPool) preWarmProcessors() {
+	p.poolLock.Lock()
+	targetIdleCount := p.warmUpCount - len(p.idleProcessors) - p.warming
+	if targetIdleCount <= 0 {
+		p.poolLock.Unlock()
+		return
+	}
+	p.warming += targetIdleCount
+	p.poolLock.Unlock()
+
+	for i := 0; i < targetIdleCount; i++ {
+		processor, err := p.createOCRProcessor()
+		p.poolLock.Lock()
+		p.warming--
+		closed := p.closed
+		if err == nil && !closed {
+			p.idleProcessors = append(p.idleProcessors, processor)
+		}
+		idle := len(p.idleProcessors)
+		p.poolLock.Unlock()
+		switch {
+		case err != nil:
+			logger.LogError("[%s] 无法预热处理器：%v", p.name, err)
+		case closed:
+			p.discard(processor)
+		default:
+			logger.LogInfo("[%s] 创建新的预热处理器。总空闲：%d", p.name, idle)
+		}
+	}
+}
+
+// close 关闭池中所有处理器的引擎，包括正在使用的处理器；关闭后不再创建引擎，此后归还的处理器由 putBack 关闭
+func (p *processorPool) close() {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+
+	for i, processor := range p.activeProcessors {
+		logger.LogInfo("[%s] 关闭活跃处理器 %d", p.name, i)
+		p.discard(processor)
+	}
+	for i, processor := range p.idleProcessors {
+		logger.LogInfo("[%s] 关闭空闲处理器 %d", p.name, i)
+		p.discard(processor)
+	}
+	for _, processor := range p.quarantined {
+		p.discard(processor)
+	}
+	p.quarantined = nil
+
+	p.activeProcessors = nil
+	p.idleProcessors = nil
+	p.closed = true
+}
+
+// discard 关闭已移出池的处理器的引擎并归还引擎名额，同一个处理器只归还一次。
+// 关闭池时正在使用的处理器会在 close 和 putBack 中各调用一次
+func (p *processorPool) discard(processor *OCRProcessor) {
+	processor.mutex.Lock()
+	engine, discarded := processor.processor, processor.discarded
+	processor.discarded = true
+	processor.mutex.Unlock()
+	engine.Close()
+	if !discarded {
+		p.engines.release()
+	}
+}
+
+// longestIdle 返回空闲池中空闲最久的处理器的空闲时间，空闲池为空时返回 false
+func (p *processorPool) longestIdle() (time.Duration, bool) {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	var longest time.Duration
+	for _, processor := range p.idleProcessors {
+		longest = max(longest, processor.idleFor())
+	}
+	return longest, len(p.idleProcessors) > 0
+}
+
+// evictIdle 关闭空闲池中空闲最久的处理器，为其他池腾出引擎名额，空闲池为空时返回 false
+func (p *processorPool) evictIdle() bool {
+	p.poolLock.Lock()
+	victim := -1
+	var longest time.Duration
+	for i, processor := range p.idleProcessors {
+		if idle := processor.idleFor(); victim < 0 || idle > longest {
+			victim, longest = i, idle
+		}
+	}
+	if victim < 0 {
+		p.poolLock.Unlock()
+		return false
+	}
+	processor := p.idleProcessors[victim]
+	p.idleProcessors = append(p.idleProcessors[:victim], p.idleProcessors[victim+1:]...)
+	p.poolLock.Unlock()
+	logger.LogInfo("[%s] 引擎实例数已达上限，关闭空闲 %v 的处理器 %p", p.name, longest.Round(time.Second), processor)
+	p.discard(processor)
+	return true
+}
+
+// poolStats 池的统计信息
+type poolStats struct {
+	Engine      string        `json:"engine"`
+	Model       string        `json:"model,omitempty"`
+	Min         int           `json:"min_processors"`
+	Max         int           `json:"max_processors"`
+	Active      int           `json:"active_processors"`
+	InUse       int           `json:"in_use_processors"`
+	Idle        int           `json:"idle_processors"`
+	TotalUsage  int64         `json:"total_usage"`
+	TotalJobs   int64         `json:"total_jobs"`             // 池累计处理的任务数
+	Recycled    int64         `json:"recycled"`               // 池累计回收替换引擎的次数
+	Quarantined int           `json:"quarantined_processors"` // 未通过健康检查、已移出轮转的处理器数
+	Dispatched  int64         `json:"dispatched_tasks"`       // 已出队、尚未完成的任务数
+	Autoscale   scaleSnapshot `json:"autoscale"`
+	Breaker     breakerStats  `json:"breaker"`
+}
+
+func (p *processorPool) stats() poolStats {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+
+	st := poolStats{
+		Engine: p.engine,
+		Model:  p.model,
+		Min:    p.minProcessors,
+		Max:    p.maxProcessors,
+		Active: len(p.activeProcessors),
+		Idle:   len(p.idleProcessors),
+	}
+	for _, processor := range p.activeProcessors {
+		if processor.inUse {
+			st.InUse++
+		}
+		st.TotalUsage += atomic.LoadInt64(&processor.usageCount)
+	}
+	st.TotalJobs = atomic.LoadInt64(&p.totalJobs)
+	st.Recycled = atomic.LoadInt64(&p.recycled)
+	st.Quarantined = len(p.quarantined)
+	st.Dispatched = atomic.LoadInt64(&p.dispatched)
+	st.Autoscale = p.scaleStats()
+	st.Breaker = p.breaker.stats()
+	return st
+}
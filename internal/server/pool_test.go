@@ -0,0 +1,455 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"ocr-server/internal/config"
+	"ocr-server/pkg/ocrengine"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTrackedPool 创建记录引擎的 fake 引擎池并初始化
+func newTrackedPool(t *testing.T, s *Server) (*processorPool, *engineTracker) {
+	t.Helper()
+	tracker := &engineTracker{}
+	pool := s.defaultPool
+	tracker.track(pool)
+	if err := pool.initialize(); err != nil {
+		t.Fatalf("初始化处理器池失败: %v", err)
+	}
+	return pool, tracker
+}
+
+// waitSettled 等待后台的回收完成：池中的处理器都未被占用，且未关闭的引擎都属于池中的处理器
+func waitSettled(t *testing.T, pool *processorPool, tracker *engineTracker) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pool.poolLock.Lock()
+		processors := append(append([]*OCRProcessor{}, pool.activeProcessors...), pool.idleProcessors...)
+		inUse := 0
+		for _, processor := range processors {
+			if processor.inUse {
+				inUse++
+			}
+		}
+		pool.poolLock.Unlock()
+		open := tracker.open()
+		if inUse == 0 && open == len(processors) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("处理器池未恢复空闲: 处理器 %d，占用 %d，未关闭的引擎 %d", len(processors), inUse, open)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// checkPool 检查信号量已全部释放，处理器不重复出现在激活和空闲列表中
+func checkPool(t *testing.T, pool *processorPool, tracker *engineTracker) {
+	t.Helper()
+	if n := len(pool.slots); n != 0 {
+		t.Errorf("信号量泄漏: %d", n)
+	}
+	pool.poolLock.Lock()
+	defer pool.poolLock.Unlock()
+	seen := make(map[*OCRProcessor]bool)
+	for _, processor := range append(append([]*OCRProcessor{}, pool.activeProcessors...), pool.idleProcessors...) {
+		if seen[processor] {
+			t.Errorf("处理器 %p 在池中出现多次", processor)
+		}
+		seen[processor] = true
+	}
+	if n := tracker.shared.Load(); n != 0 {
+		t.Errorf("引擎被同时使用 %d 次", n)
+	}
+}
+
+// TestPoolConcurrentUse 任务与健康检查、回收、缩容和补足最小处理器并发进行时，
+// 处理器同一时间只属于一个使用者，结束后没有信号量、处理器或引擎泄漏
+func TestPoolConcurrentUse(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors:    2,
+		MaxProcessors:    4,
+		WarmUpCount:      1,
+		RecycleAfterJobs: 3,
+		RecycleAfterAge:  5 * time.Millisecond,
+		FakeEngine:       ocrengine.FakeOptions{Latency: time.Millisecond},
+	})
+	pool, tracker := newTrackedPool(t, s)
+	ctx := context.Background()
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			pool.healthCheck(ctx)
+			pool.recycleIdle()
+			pool.scaleDown(1)
+			pool.ensureMinProcessors()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var tasks sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		tasks.Add(1)
+		go func() {
+			defer tasks.Done()
+			for j := 0; j < 25; j++ {
+				processor, err := pool.acquire(ctx)
+				if err != nil {
+					t.Errorf("获取处理器失败: %v", err)
+					return
+				}
+				if _, err := processor.engine().Recognize(ctx, []byte("image")); err != nil {
+					t.Errorf("识别失败: %v", err)
+				}
+				atomic.AddInt64(&processor.jobs, 1)
+				pool.release(processor)
+			}
+		}()
+	}
+	tasks.Wait()
+	close(stop)
+	background.Wait()
+
+	waitSettled(t, pool, tracker)
+	checkPool(t, pool, tracker)
+	if n := tracker.afterClose.Load(); n != 0 {
+		t.Errorf("使用了已关闭的引擎 %d 次", n)
+	}
+	if atomic.LoadInt64(&pool.recycled) == 0 {
+		t.Error("没有发生回收")
+	}
+}
+
+// TestPoolCloseWhileInUse 任务和回收进行中关闭池，之后不再创建引擎，所有引擎都被关闭，信号量全部释放
+func TestPoolCloseWhileInUse(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors:    2,
+		MaxProcessors:    4,
+		RecycleAfterJobs: 2,
+		FakeEngine:       ocrengine.FakeOptions{Latency: time.Millisecond},
+	})
+	pool, tracker := newTrackedPool(t, s)
+	ctx := context.Background()
+
+	var tasks sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		tasks.Add(1)
+		go func() {
+			defer tasks.Done()
+			for {
+				processor, err := pool.acquire(ctx)
+				if err != nil {
+					if !errors.Is(err, errPoolClosed) {
+						t.Errorf("池关闭后 acquire 返回 %v，应为 errPoolClosed", err)
+					}
+					return
+				}
+				// 池关闭时正在进行的识别会失败
+				processor.engine().Recognize(ctx, []byte("image"))
+				atomic.AddInt64(&processor.jobs, 1)
+				pool.release(processor)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	checked := make(chan struct{})
+	go func() {
+		pool.healthCheck(ctx)
+		close(checked)
+	}()
+	pool.close()
+	tasks.Wait()
+	<-checked
+
+	deadline := time.Now().Add(5 * time.Second)
+	// 后台回收在引擎关闭后才释放信号量
+	for tracker.open() != 0 || len(pool.slots) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("池关闭后仍有 %d 个引擎未关闭、%d 个信号量未释放", tracker.open(), len(pool.slots))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	checkPool(t, pool, tracker)
+}
+
+// TestProcessTaskCancelled 客户端在识别中断开时任务中止，处理器和信号量归还给池
+func TestProcessTaskCancelled(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors: 1,
+		MaxProcessors: 1,
+		FakeEngine:    ocrengine.FakeOptions{Latency: time.Second},
+	})
+	pool, tracker := newTrackedPool(t, s)
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	task := ocrTask{Ctx: reqCtx, ImageData: testImage(t), Response: make(chan ocrResponse, 1)}
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	s.wg.Add(1)
+	s.processTask(context.Background(), task)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("客户端断开后任务仍运行了 %v", elapsed)
+	}
+	select {
+	case response := <-task.Response:
+		t.Errorf("已取消的任务返回了结果: %+v", response)
+	default:
+	}
+	if n := atomic.LoadInt64(&s.stats.CancelledRequests); n != 1 {
+		t.Errorf("cancelled_requests = %d，应为 1", n)
+	}
+	waitSettled(t, pool, tracker)
+	checkPool(t, pool, tracker)
+}
+
+// TestEngineLimit 所有池的引擎实例数达到 max_engines 时，新池先关闭其他池空闲的处理器，没有空闲处理器时返回 errEngineLimit
+func TestEngineLimit(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors:      1,
+		MaxProcessors:      2,
+		MaxEngines:         2,
+		BreakerFailureRate: 50,
+		BreakerWindow:      10,
+	})
+	defaultPool, _ := newTrackedPool(t, s)
+	other := s.getPool(ocrengine.FakeEngineName, "other")
+	ctx := context.Background()
+	count := func() int { return s.engines.stats().Count }
+
+	// 默认池扩到两个处理器，归还后第二个进入空闲池
+	first, err := defaultPool.acquire(ctx)
+	if err != nil {
+		t.Fatalf("获取处理器失败: %v", err)
+	}
+	second, err := defaultPool.acquire(ctx)
+	if err != nil {
+		t.Fatalf("获取处理器失败: %v", err)
+	}
+	defaultPool.release(second)
+	defaultPool.release(first)
+	if n := count(); n != 2 {
+		t.Fatalf("引擎实例数为 %d，应为 2", n)
+	}
+
+	// 新池关闭默认池空闲的处理器后创建自己的处理器
+	held, err := other.acquire(ctx)
+	if err != nil {
+		t.Fatalf("引擎实例数达到上限时应关闭其他池的空闲处理器: %v", err)
+	}
+	if st := defaultPool.stats(); st.Active+st.Idle != 1 || count() != 2 {
+		t.Fatalf("默认池剩余 %d 个处理器、引擎实例数 %d，应为 1 和 2", st.Active+st.Idle, count())
+	}
+
+	// 其他池没有空闲处理器时不能再创建
+	first, err = defaultPool.acquire(ctx)
+	if err != nil {
+		t.Fatalf("获取处理器失败: %v", err)
+	}
+	if _, err := defaultPool.acquire(ctx); !errors.Is(err, errEngineLimit) {
+		t.Fatalf("错误为 %v，应为 errEngineLimit", err)
+	}
+	if oe := classify(errEngineLimit); oe.code != codeBusy {
+		t.Fatalf("引擎实例数达到上限时错误码为 %s，应为 %s", oe.code, codeBusy)
+	}
+	if st := defaultPool.breaker.stats(); st.Samples != 0 {
+		t.Fatalf("引擎实例数达到上限不应计入熔断: %+v", st)
+	}
+	defaultPool.release(first)
+	other.release(held)
+
+	s.cleanup()
+	if n := count(); n != 0 {
+		t.Fatalf("关闭所有池后引擎实例数为 %d，应为 0", n)
+	}
+}
+
+// TestPreWarmOutsideLock 预热创建引擎时不持有池锁，同时进行的预热不会超过 warm_up_count
+func TestPreWarmOutsideLock(t *testing.T) {
+	s := newTestServer(t, config.Config{MinProcessors: 1, MaxProcessors: 4, WarmUpCount: 2})
+	pool, tracker := newTrackedPool(t, s)
+	if removed := pool.scaleDown(2); removed != 2 {
+		t.Fatalf("关闭了 %d 个预热处理器，应为 2", removed)
+	}
+
+	gate := make(chan struct{})
+	var creating atomic.Int64
+	newEngine := pool.newEngine
+	pool.newEngine = func() (ocrengine.Engine, error) {
+		creating.Add(1)
+		<-gate
+		return newEngine()
+	}
+	var warming sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		warming.Add(1)
+		go func() {
+			defer warming.Done()
+			pool.preWarmProcessors()
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for creating.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("没有开始创建预热处理器")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		pool.stats()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		close(gate)
+		t.Fatal("创建预热处理器时持有池锁")
+	}
+	close(gate)
+	warming.Wait()
+
+	if n := creating.Load(); n != 2 {
+		t.Fatalf("创建了 %d 个预热处理器，应为 2", n)
+	}
+	if st := pool.stats(); st.Idle != 2 {
+		t.Fatalf("空闲处理器为 %d，应为 2", st.Idle)
+	}
+	checkPool(t, pool, tracker)
+}
+
+// TestProcessTaskTimeout 识别超过 task_timeout 时任务返回超时，中断的引擎被替换，处理器和信号量归还给池
+func TestProcessTaskTimeout(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors: 1,
+		MaxProcessors: 1,
+		TaskTimeout:   20 * time.Millisecond,
+		FakeEngine:    ocrengine.FakeOptions{Latency: time.Second},
+	})
+	pool, tracker := newTrackedPool(t, s)
+
+	task := ocrTask{ImageData: testImage(t), Response: make(chan ocrResponse, 1)}
+	start := time.Now()
+	s.wg.Add(1)
+	s.processTask(context.Background(), task)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("超时后任务仍运行了 %v", elapsed)
+	}
+	if response := <-task.Response; response.Code != codeTimeout {
+		t.Errorf("错误码为 %q，应为 %s", response.Code, codeTimeout)
+	}
+	waitSettled(t, pool, tracker)
+	checkPool(t, pool, tracker)
+}
+
+// TestShutdownDuringBackoff 引擎出错后在退避等待期间服务器关闭，返回 shutting_down 而不是上一次的引擎错误
+func TestShutdownDuringBackoff(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors: 1,
+		MaxProcessors: 1,
+		FakeEngine:    ocrengine.FakeOptions{FailEvery: 1},
+	})
+	pool, tracker := newTrackedPool(t, s)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(100*time.Millisecond, func() { cancel(errShuttingDown) })
+	task := ocrTask{ImageData: testImage(t), Response: make(chan ocrResponse, 1)}
+	s.wg.Add(1)
+	s.processTask(ctx, task)
+	if response := <-task.Response; response.Code != codeShuttingDown || response.status != http.StatusServiceUnavailable {
+		t.Errorf("退避期间关闭返回 %s（%d），应为 %s", response.Code, response.status, codeShuttingDown)
+	}
+	waitSettled(t, pool, tracker)
+	checkPool(t, pool, tracker)
+}
+
+// TestRecycleHoldsSlot max_processors 为 1 时，回收中的处理器继续占用信号量，
+// 等待的任务拿到的是替换引擎后的同一个处理器，池中不会出现第二个处理器
+func TestRecycleHoldsSlot(t *testing.T) {
+	s := newTestServer(t, config.Config{
+		MinProcessors:    1,
+		MaxProcessors:    1,
+		RecycleAfterJobs: 1,
+		FakeEngine:       ocrengine.FakeOptions{Latency: time.Millisecond},
+	})
+	pool, tracker := newTrackedPool(t, s)
+	// 替换引擎时放慢创建，让任务在回收期间等待
+	newEngine := pool.newEngine
+	pool.newEngine = func() (ocrengine.Engine, error) {
+		time.Sleep(20 * time.Millisecond)
+		return newEngine()
+	}
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		processor, err := pool.acquire(ctx)
+		if err != nil {
+			t.Fatalf("获取处理器失败: %v", err)
+		}
+		if open := tracker.open(); open > 1 {
+			t.Fatalf("第 %d 次获取时有 %d 个引擎，max_processors 为 1", i+1, open)
+		}
+		atomic.AddInt64(&processor.jobs, 1)
+		pool.release(processor)
+	}
+	waitSettled(t, pool, tracker)
+	if st := pool.stats(); st.Active+st.Idle != 1 || st.Recycled == 0 {
+		t.Fatalf("池中有 %d 个处理器、回收 %d 次，应为 1 个处理器且发生过回收", st.Active+st.Idle, st.Recycled)
+	}
+	checkPool(t, pool, tracker)
+}
+
+// TestHealthCheckConcurrent 健康检查同时检查多个处理器，每个处理器只有一个使用者，检查期间仍能为任务分配处理器
+func TestHealthCheckConcurrent(t *testing.T) {
+	probe := filepath.Join(t.TempDir(), "probe.png")
+	if err := os.WriteFile(probe, testImage(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const latency = 200 * time.Millisecond
+	s := newTestServer(t, config.Config{
+		MinProcessors:    4,
+		MaxProcessors:    5,
+		HealthProbeImage: probe,
+		FakeEngine:       ocrengine.FakeOptions{Latency: latency},
+	})
+	pool, tracker := newTrackedPool(t, s)
+
+	done := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		pool.healthCheck(context.Background())
+		done <- time.Since(start)
+	}()
+	// 检查进行中时，剩余的名额仍可以分配给任务
+	time.Sleep(latency / 4)
+	processor, err := pool.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("健康检查期间获取处理器失败: %v", err)
+	}
+	pool.release(processor)
+
+	if elapsed := <-done; elapsed >= 2*latency {
+		t.Fatalf("检查 4 个处理器用了 %v，应并行进行", elapsed)
+	}
+	waitSettled(t, pool, tracker)
+	checkPool(t, pool, tracker)
+	if st := pool.stats(); st.Active+st.Idle != 5 || st.Quarantined != 0 {
+		t.Fatalf("健康检查后有 %d 个处理器、%d 个隔离，应为 5 和 0", st.Active+st.Idle, st.Quarantined)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"ocr-server/logger"
+	"ocr-server/pkg/ocrengine"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// recycleReason 判断处理器的引擎是否需要回收：任务数、运行时间或进程常驻内存超过配置值，返回原因，不需要时返回空字符串
+func (p *processorPool) recycleReason(processor *OCRProcessor) string {
+	if p.recycleJobs > 0 {
+		if jobs := atomic.LoadInt64(&processor.jobs); jobs >= p.recycleJobs {
+			return fmt.Sprintf("已处理 %d 个任务", jobs)
+		}
+	}
+	if age := processor.age(); p.recycleAge > 0 && age >= p.recycleAge {
+		return fmt.Sprintf("已运行 %v", age.Round(time.Second))
+	}
+	if p.recycleRSS > 0 {
+		if proc, ok := processor.engine().(ocrengine.Process); ok {
+			if rss, err := processRSS(proc.PID()); err == nil && rss >= p.recycleRSS {
+				return fmt.Sprintf("常驻内存 %d MB", rss>>20)
+			}
+		}
+	}
+	return ""
+}
+
+// recycle 关闭并替换处理器的引擎，调用方已独占处理器并持有信号量；完成后归还处理器，由调用方释放信号量
+func (p *processorPool) recycle(processor *OCRProcessor, reason string) {
+	logger.LogInfo("[%s] 回收处理器 %p：%s", p.name, processor, reason)
+	if err := p.replaceEngine(processor); err != nil {
+		// 引擎已关闭，新引擎创建失败时移除该处理器，由 acquire 或自动伸缩按需补充
+		logger.LogError("[%s] 回收处理器时创建引擎失败：%v", p.name, err)
+		p.remove(processor)
+		p.discard(processor)
+		return
+	}
+	atomic.AddInt64(&processor.recycles, 1)
+	atomic.AddInt64(&p.recycled, 1)
+	p.putBack(processor)
+}
+
+// recycleIdle 检查未在使用的处理器，回收超过任务数、运行时间或内存限制的引擎，
+// 回收期间处理器移出空闲池并占用信号量，不会分配给任务；没有空闲的信号量时留到下一次检查
+func (p *processorPool) recycleIdle() {
+	if p.recycleJobs == 0 && p.recycleAge == 0 && p.recycleRSS == 0 {
+		return
+	}
+	type candidate struct {
+		processor *OCRProcessor
+		reason    string
+	}
+	var candidates []candidate
+	p.poolLock.Lock()
+	for _, processors := range [][]*OCRProcessor{p.activeProcessors, p.idleProcessors} {
+		for _, processor := range processors {
+			if processor.inUse {
+				continue
+			}
+			if reason := p.recycleReason(processor); reason != "" {
+				candidates = append(candidates, candidate{processor, reason})
+			}
+		}
+	}
+	p.poolLock.Unlock()
+	for _, c := range candidates {
+		if p.checkout(c.processor) {
+			go func(c candidate) {
+				p.recycle(c.processor, c.reason)
+				<-p.slots
+			}(c)
+		}
+	}
+}
+
+// remove 从池中移除处理器
+func (p *processorPool) remove(processor *OCRProcessor) {
+	p.poolLock.Lock()
+	defer p.poolLock.Unlock()
+	for i, ap := range p.activeProcessors {
+		if ap == processor {
+			p.activeProcessors = append(p.activeProcessors[:i], p.activeProcessors[i+1:]...)
+			return
+		}
+	}
+	for i, ip := range p.idleProcessors {
+		if ip == processor {
+			p.idleProcessors = append(p.idleProcessors[:i], p.idleProcessors[i+1:]...)
+			return
+		}
+	}
+}
+
+// processRSS 从 /proc/<pid>/status 读取进程的常驻内存（字节），不支持 /proc 的平台返回错误
+func processRSS(pid int) (int64, error) {
+	if pid <= 0 {
+		return 0, fmt.Errorf("无效的进程 ID: %d", pid)
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			break
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb << 10, nil
+	}
+	return 0, fmt.Errorf("/proc/%d/status 中没有 VmRSS", pid)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"ocr-server/logger"
+	"strings"
+)
+
+// codeNotFound 请求的路径不存在
+const codeNotFound = "not_found"
+
+// routes 注册 HTTP 路由。新接口放在 /v1 下，POST /、POST /ocr 和 GET /stats 保留给旧版本客户端
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/ocr", s.allow(s.handleOCR, http.MethodPost))
+	mux.Handle("/v1/ocr/batch", s.allow(s.handleBatch, http.MethodPost))
+	mux.Handle("/v1/jobs", s.allow(s.handleCreateJob, http.MethodPost))
+	mux.Handle("/v1/jobs/{id}", s.allow(s.handleJob, http.MethodGet, http.MethodDelete))
+	mux.Handle("/v1/stats", s.allow(s.handleStats, http.MethodGet))
+	mux.Handle("/healthz", s.allow(s.handleHealthz, http.MethodGet))
+	mux.Handle("/readyz", s.allow(s.handleReadyz, http.MethodGet))
+
+	// 兼容旧版本的接口
+	mux.Handle("/{$}", s.allow(s.handleOCR, http.MethodPost))
+	mux.Handle("/ocr", s.allow(s.handleOCR, http.MethodPost))
+	mux.Handle("/stats", s.allow(s.handleStats, http.MethodGet))
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		logger.LogInfo("请求的路径不存在: %s %s", r.Method, r.URL.Path)
+		s.writeError(w, &ocrError{codeNotFound, http.StatusNotFound, errors.New("接口不存在")})
+	})
+	return mux
+}
+
+// allow 只允许指定的请求方法，GET 接口同时接受 HEAD，其他方法返回 405
+func (s *Server) allow(handler http.HandlerFunc, methods ...string) http.Handler {
+	allowed := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method || (r.Method == http.MethodHead && method == http.MethodGet) {
+				handler(w, r)
+				return
+			}
+		}
+		logger.LogInfo("收到不支持的请求方法: %s %s", r.Method, r.URL.Path)
+		w.Header().Set("Allow", allowed)
+		s.writeError(w, &ocrError{codeMethodNotAllowed, http.StatusMethodNotAllowed, errors.New("不支持的请求方法")})
+	})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	logger.LogInfo("收到获取服务器状态的请求")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.GetStats())
+}
+
+// handleHealthz 存活检查，进程能响应请求即返回 200
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadyz 就绪检查，服务器未在关闭且默认处理器池可以接受任务时返回 200，否则返回 503
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "not_ready", "reason": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
+
+// ready 服务器是否可以接受新任务
+func (s *Server) ready() error {
+	if s.stopping.Load() {
+		return errors.New("服务器正在关闭")
+	}
+	if s.defaultPool.breaker.isOpen() {
+		return errCircuitOpen
+	}
+	return s.defaultPool.ready()
+}
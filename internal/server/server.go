@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"ocr-server/internal/config"
 	"ocr-server/logger"
-	"runtime"
+	"ocr-server/pkg/ocrengine"
 
 	"net/http"
 	"os"
@@ -17,34 +17,57 @@ import (
 	"time"
 )
 
+// errShuttingDown 服务器关闭时取消进行中任务的原因，用于和客户端取消区分
+var errShuttingDown = errors.New("服务器正在关闭")
+
 type Server struct {
-	config           config.Config
-	activeProcessors []*OCRProcessor //活跃的处理器
-	idleProcessors   []*OCRProcessor
-	taskQueue        chan ocrTask
-	poolLock         sync.Mutex
-	processorCond    *sync.Cond
-	shutdownChan     chan struct{}
-	wg               sync.WaitGroup
-	stats            *ServerStats
+	config       config.Config
+	defaultPool  *processorPool            // 配置的默认引擎对应的处理器池
+	pools        map[string]*processorPool // 按引擎和模型划分的处理器池，键为 poolName
+	poolsLock    sync.Mutex
+	engines      *engineLimiter // 所有池共享的引擎实例数上限
+	lanes        *laneScheduler // 按优先级划分的任务队列
+	clients      *clientRegistry
+	jobs         *jobRegistry
+	throughput   *rateMeter // 最近一分钟完成的任务数，用于估算 Retry-After
+	admission    admissionCounters
+	stopping     atomic.Bool // 收到关闭信号后为 true，/readyz 返回 503
+	shutdownChan chan struct{}
+	wg           sync.WaitGroup
+	stats        *ServerStats
 }
 type ServerStats struct {
 	TotalRequests         int64
 	SuccessfulRequests    int64
 	FailedRequests        int64
+	CancelledRequests     int64        // 客户端断开连接而中止的请求，不计入 TotalRequests
 	AverageProcessingTime atomic.Value // stores time.Duration
+	averageLock           sync.Mutex   // 串行化平均处理时间的读-改-写
 }
 
 func NewServer(cfg config.Config) (*Server, error) {
+	if cfg.Engine == "" {
+		cfg.Engine = ocrengine.DefaultEngine
+	}
+	if cfg.MaxEngines > 0 && cfg.MaxEngines < cfg.MinProcessors {
+		return nil, fmt.Errorf("max_engines（%d）不能小于 min_processors（%d）", cfg.MaxEngines, cfg.MinProcessors)
+	}
+	if len(cfg.Lanes) == 0 {
+		cfg.Lanes = defaultLanes(cfg.QueueSize)
+	}
 	s := &Server{
-		config:           cfg,
-		activeProcessors: make([]*OCRProcessor, 0, cfg.MaxProcessors),
-		idleProcessors:   make([]*OCRProcessor, 0, cfg.MaxProcessors),
-		taskQueue:        make(chan ocrTask, cfg.QueueSize),
-		shutdownChan:     make(chan struct{}),
-		stats:            &ServerStats{},
+		config:       cfg,
+		pools:        make(map[string]*processorPool),
+		shutdownChan: make(chan struct{}),
+		stats:        &ServerStats{},
+		lanes:        newLaneScheduler(cfg.Lanes, cfg.DefaultPriority, cfg.AdmissionPolicy, cfg.AdmissionWait, cfg.MaxInFlight),
+		throughput:   newRateMeter(),
+		clients:      newClientRegistry(cfg),
+		jobs:         newJobRegistry(cfg.JobTTL, cfg.MaxJobs),
 	}
-	s.processorCond = sync.NewCond(&s.poolLock)
+	s.engines = newEngineLimiter(cfg.MaxEngines, s.evictIdle)
+	s.defaultPool = newProcessorPool(cfg.Engine, "", cfg.MinProcessors, cfg.MaxProcessors, cfg.WarmUpCount, s)
+	s.pools[s.defaultPool.name] = s.defaultPool
 	s.stats.AverageProcessingTime.Store(time.Duration(0))
 	return s, nil
 }
@@ -53,43 +76,72 @@ func NewServer(cfg config.Config) (*Server, error) {
 func (s *Server) Initialize() error {
 	logger.LogInfo("初始化 OCR 处理器...")
 
-	for i := 0; i < s.config.MinProcessors; i++ {
-		processor, err := s.createOCRProcessor()
-		if err != nil {
-			logger.LogInfo("初始化处理器 %d 失败: %v", i, err)
-			return fmt.Errorf("初始化处理器 %d 失败: %w", i, err)
-		}
-		s.activeProcessors = append(s.activeProcessors, processor)
-		logger.LogInfo("处理器 %d 已初始化", i)
+	if err := s.defaultPool.initialize(); err != nil {
+		return fmt.Errorf("初始化处理器失败: %w", err)
 	}
 
-	logger.LogInfo("预热额外处理器...")
-	for i := 0; i < s.config.WarmUpCount; i++ {
-		processor, err := s.createOCRProcessor()
-		if err != nil {
-			logger.LogInfo("无法预热处理器 %d：%v", i, err)
-			continue
+	st := s.defaultPool.stats()
+	logger.LogInfo("%d 个激活的 OCR 处理器已初始化，%d 个预热处理器已准备好。\n", st.Active, st.Idle)
+	return nil
+}
+
+// getPool 返回指定引擎和模型的处理器池，其他池在首次使用时创建，并在后台补足该模型的最小处理器数量。
+// 可用的模型由 resolveModel 限制在 models 和 allowed_langs 中，池的数量有上限；各池的处理器总数受 max_engines 限制
+func (s *Server) getPool(engine, model string) *processorPool {
+	if engine == "" {
+		engine = s.config.Engine
+	}
+	if engine == s.config.Engine && model == "" {
+		return s.defaultPool
+	}
+	s.poolsLock.Lock()
+	defer s.poolsLock.Unlock()
+	name := poolName(engine, model)
+	pool, ok := s.pools[name]
+	if !ok {
+		minProcessors, maxProcessors := 0, s.config.MaxProcessors
+		if model != "" {
+			mc := s.modelConfig(model)
+			minProcessors = mc.MinProcessors
+			if mc.MaxProcessors > 0 {
+				maxProcessors = mc.MaxProcessors
+			}
 		}
-		s.idleProcessors = append(s.idleProcessors, processor)
-		logger.LogInfo("预热处理器 %d 已创建", i)
+		pool = newProcessorPool(engine, model, minProcessors, maxProcessors, 0, s)
+		s.pools[name] = pool
+		logger.LogInfo("创建处理器池 %s，最小：%d，最大：%d", name, minProcessors, maxProcessors)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			pool.ensureMinProcessors()
+		}()
 	}
+	return pool
+}
 
-	logger.LogInfo("%d 个激活的 OCR 处理器已初始化，%d 个预热处理器已准备好。\n", len(s.activeProcessors), len(s.idleProcessors))
-	return nil
+// allPools 返回当前所有处理器池的快照
+func (s *Server) allPools() []*processorPool {
+	s.poolsLock.Lock()
+	defer s.poolsLock.Unlock()
+	pools := make([]*processorPool, 0, len(s.pools))
+	for _, pool := range s.pools {
+		pools = append(pools, pool)
+	}
+	return pools
 }
 
 // Start 启动server
 func (s *Server) Start() {
 	logger.LogInfo("启动 OCR 服务器于 %s:%d，激活处理器数量：%d",
-		s.config.Addr, s.config.Port, len(s.activeProcessors))
+		s.config.Addr, s.config.Port, s.defaultPool.stats().Active)
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", s.config.Addr, s.config.Port),
-		Handler: http.HandlerFunc(s.handleOCR),
+		Handler: s.routes(),
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
 	s.wg.Add(1)
 	go s.processQueue(ctx)
@@ -97,6 +149,9 @@ func (s *Server) Start() {
 	s.wg.Add(1)
 	go s.monitorProcessors(ctx)
 
+	s.wg.Add(1)
+	go s.healthLoop(ctx)
+
 	go func() {
 		logger.LogInfo("HTTP 服务器监听端口号：%d", s.config.Port)
 		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -107,14 +162,15 @@ func (s *Server) Start() {
 	s.waitForShutdown(ctx, cancel, server)
 }
 
-func (s *Server) waitForShutdown(ctx context.Context, cancel context.CancelFunc, server *http.Server) {
+func (s *Server) waitForShutdown(ctx context.Context, cancel context.CancelCauseFunc, server *http.Server) {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	<-stop
 	logger.LogInfo("接收到关闭信号，开始优雅关闭...")
+	s.stopping.Store(true)
 
-	cancel() // 取消 context，通知所有使用该 context 的 goroutine
+	cancel(errShuttingDown) // 取消 context，通知所有使用该 context 的 goroutine
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 	defer shutdownCancel()
@@ -146,21 +202,10 @@ func (s *Server) waitForShutdown(ctx context.Context, cancel context.CancelFunc,
 func (s *Server) cleanup() {
 	logger.LogInfo("清理资源...")
 
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	for i, p := range s.activeProcessors {
-		logger.LogInfo("关闭活跃处理器 %d", i)
-		p.processor.Close()
-	}
-	for i, p := range s.idleProcessors {
-		logger.LogInfo("关闭空闲处理器 %d", i)
-		p.processor.Close()
+	for _, pool := range s.allPools() {
+		pool.close()
 	}
 
-	s.activeProcessors = nil
-	s.idleProcessors = nil
-
 	logger.LogInfo("所有资源已清理")
 }
 
@@ -170,14 +215,32 @@ func (s *Server) monitorProcessors(ctx context.Context) {
 	logger.LogInfo("处理器监控已启动")
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
+	// 自动伸缩使用单独的、更短的周期；scale_interval 为 0 时不启用，空闲的处理器仍在检查周期中关闭
+	var scaleTick <-chan time.Time
+	if s.config.ScaleInterval > 0 {
+		scaleTicker := time.NewTicker(s.config.ScaleInterval)
+		defer scaleTicker.Stop()
+		scaleTick = scaleTicker.C
+	}
 
 	for {
 		select {
 		case <-ticker.C:
 			logger.LogInfo("运行定期处理器检查")
-			s.checkAndScaleDown()
-			s.PreWarmProcessors()
-			s.healthCheck()
+			for _, pool := range s.allPools() {
+				pool.ensureMinProcessors()
+				pool.recycleIdle()
+				pool.preWarmProcessors()
+				if scaleTick == nil {
+					pool.trimIdle()
+				}
+			}
+			s.jobs.expire()
+		case <-scaleTick:
+			queued := s.lanes.queuedByPool(s.config.Engine)
+			for _, pool := range s.allPools() {
+				pool.autoscale(int64(queued[pool.name]))
+			}
 		case <-ctx.Done():
 			logger.LogInfo("处理器监控正在关闭")
 			return
@@ -185,24 +248,36 @@ func (s *Server) monitorProcessors(ctx context.Context) {
 	}
 }
 
+// processQueue 按权重从各优先级队列取出有执行名额的任务，任务在队列中等待而不是在处理器池中等待，
+// 这样高优先级任务不会排在已经出队的批量任务之后，某个池占满时其他池的任务也不受影响
 func (s *Server) processQueue(ctx context.Context) {
 	defer s.wg.Done()
 	logger.LogInfo("任务队列处理器已启动")
 
 	for {
-		select {
-		case task := <-s.taskQueue:
-			s.wg.Add(1)
-			go s.processTask(ctx, task)
-		case <-ctx.Done():
-			logger.LogInfo("任务队列处理器正在关闭")
-			return
+		task, ok := s.lanes.next()
+		for !ok {
+			select {
+			case <-s.lanes.notify:
+				task, ok = s.lanes.next()
+			case <-ctx.Done():
+				logger.LogInfo("任务队列处理器正在关闭")
+				return
+			}
 		}
+		s.wg.Add(1)
+		go func() {
+			defer func() {
+				s.lanes.done(task)
+				s.throughput.record()
+			}()
+			s.processTask(ctx, task)
+		}()
 	}
 }
 
 func (s *Server) updateStats(processingTime time.Duration, success bool) {
-	atomic.AddInt64(&s.stats.TotalRequests, 1)
+	total := atomic.AddInt64(&s.stats.TotalRequests, 1)
 	if success {
 		atomic.AddInt64(&s.stats.SuccessfulRequests, 1)
 	} else {
@@ -210,82 +285,9 @@ func (s *Server) updateStats(processingTime time.Duration, success bool) {
 	}
 
 	// 更新平均处理时间
+	s.stats.averageLock.Lock()
+	defer s.stats.averageLock.Unlock()
 	oldAvg := s.stats.AverageProcessingTime.Load().(time.Duration)
-	newAvg := oldAvg + (processingTime-oldAvg)/time.Duration(s.stats.TotalRequests)
+	newAvg := oldAvg + (processingTime-oldAvg)/time.Duration(total)
 	s.stats.AverageProcessingTime.Store(newAvg)
 }
-
-func (s *Server) checkAndScaleDown() {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-	logger.LogInfo("检查是否需要缩减处理器数量")
-
-	for i := len(s.activeProcessors) - 1; i >= s.config.MinProcessors; i-- {
-		processor := s.activeProcessors[i]
-		if atomic.LoadInt64(&processor.usageCount) <= s.config.DegradeThreshold &&
-			time.Since(processor.lastUsed) > s.config.IdleTimeout {
-			s.activeProcessors = s.activeProcessors[:i]
-			s.idleProcessors = append(s.idleProcessors, processor)
-			logger.LogInfo("处理器已移至空闲池。激活：%d，空闲：%d", len(s.activeProcessors), len(s.idleProcessors))
-		}
-	}
-
-	maxIdleProcessors := runtime.NumCPU() - len(s.activeProcessors)
-	for len(s.idleProcessors) > maxIdleProcessors {
-		processor := s.idleProcessors[len(s.idleProcessors)-1]
-		s.idleProcessors = s.idleProcessors[:len(s.idleProcessors)-1]
-		processor.processor.Close()
-		logger.LogInfo("关闭多余的空闲处理器。空闲：%d", len(s.idleProcessors))
-	}
-}
-
-func (s *Server) PreWarmProcessors() {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	logger.LogInfo("预热处理器")
-
-	targetIdleCount := s.config.WarmUpCount - len(s.idleProcessors)
-	for i := 0; i < targetIdleCount; i++ {
-		processor, err := s.createOCRProcessor()
-		if err != nil {
-			logger.LogError("无法预热处理器：%v", err)
-			continue
-		}
-		s.idleProcessors = append(s.idleProcessors, processor)
-		logger.LogInfo("创建新的预热处理器。总空闲：%d", len(s.idleProcessors))
-	}
-	logger.LogInfo("预热完成。激活：%d，空闲：%d", len(s.activeProcessors), len(s.idleProcessors))
-}
-
-func (s *Server) healthCheck() {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-	logger.LogInfo("开始对所有处理器进行健康检查")
-	s.healthCheckProcessors(s.activeProcessors)
-	s.healthCheckProcessors(s.idleProcessors)
-	logger.LogInfo("健康检查完成。激活：%d，空闲：%d", len(s.activeProcessors), len(s.idleProcessors))
-}
-
-func (s *Server) healthCheckProcessors(processors []*OCRProcessor) {
-	for i, processor := range processors {
-		processor.mutex.Lock()
-		logger.LogInfo("检查处理器 %d 的健康状态", i)
-		_, err := processor.processor.OcrAndParse([]byte("Hello World"))
-		processor.mutex.Unlock()
-
-		if err != nil {
-			logger.LogError("处理器 %d 未通过健康检查：%v", i, err)
-			logger.LogError("尝试重新初始化处理器 %d", i)
-			newProcessor, err := s.createOCRProcessor()
-			if err != nil {
-				logger.LogError("无法重新初始化处理器 %d：%v", i, err)
-				continue
-			}
-			*processor = *newProcessor
-			logger.LogError("成功重新初始化处理器 %d", i)
-		} else {
-			logger.LogInfo("处理器 %d 通过健康检查", i)
-		}
-	}
-}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"ocr-server/internal/config"
+	"ocr-server/pkg/ocrengine"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// newTestServer 创建使用 fake 引擎的服务器，未设置的处理器数量使用较小的值，测试结束时关闭所有池
+func newTestServer(t *testing.T, cfg config.Config) *Server {
+	t.Helper()
+	cfg.Engine = ocrengine.FakeEngineName
+	if cfg.MaxProcessors == 0 {
+		cfg.MaxProcessors = 2
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = 16
+	}
+	if cfg.ThresholdValue == 0 {
+		cfg.ThresholdValue = 100
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("创建服务器失败: %v", err)
+	}
+	t.Cleanup(s.cleanup)
+	return s
+}
+
+// testImage 生成一张可以解码的 PNG 图像
+func testImage(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 4)
+	}
+	img.Set(0, 0, color.White)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("编码测试图像失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// engineTracker 记录池创建的引擎，检查引擎是否被同时使用、关闭后是否仍被使用，以及是否都已关闭
+type engineTracker struct {
+	mutex      sync.Mutex
+	engines    []*trackedEngine
+	shared     atomic.Int64 // 同一个引擎被同时使用的次数
+	afterClose atomic.Int64 // 引擎关闭后仍被使用的次数
+}
+
+// trackedEngine 包装 fake 引擎，同一时间只允许一个调用
+type trackedEngine struct {
+	ocrengine.Engine
+	tracker *engineTracker
+	busy    atomic.Bool
+	closed  atomic.Bool
+}
+
+// track 替换池创建引擎的函数，之后创建的引擎都会被记录
+func (tr *engineTracker) track(p *processorPool) {
+	newEngine := p.newEngine
+	p.newEngine = func() (ocrengine.Engine, error) {
+		engine, err := newEngine()
+		if err != nil {
+			return nil, err
+		}
+		te := &trackedEngine{Engine: engine, tracker: tr}
+		tr.mutex.Lock()
+		tr.engines = append(tr.engines, te)
+		tr.mutex.Unlock()
+		return te, nil
+	}
+}
+
+// open 返回尚未关闭的引擎数
+func (tr *engineTracker) open() int {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	n := 0
+	for _, e := range tr.engines {
+		if !e.closed.Load() {
+			n++
+		}
+	}
+	return n
+}
+
+func (e *trackedEngine) enter() {
+	if !e.busy.CompareAndSwap(false, true) {
+		e.tracker.shared.Add(1)
+	}
+	if e.closed.Load() {
+		e.tracker.afterClose.Add(1)
+	}
+}
+
+func (e *trackedEngine) leave() {
+	e.busy.Store(false)
+}
+
+func (e *trackedEngine) Recognize(ctx context.Context, image []byte) (paddleocr.Result, error) {
+	e.enter()
+	defer e.leave()
+	return e.Engine.Recognize(ctx, image)
+}
+
+func (e *trackedEngine) HealthCheck() error {
+	e.enter()
+	defer e.leave()
+	return e.Engine.HealthCheck()
+}
+
+func (e *trackedEngine) Close() error {
+	e.closed.Store(true)
+	return e.Engine.Close()
+}
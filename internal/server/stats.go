@@ -7,16 +7,15 @@ import (
 )
 
 func (s *Server) GetStats() map[string]interface{} {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	activeCount := 0
-	totalUsage := int64(0)
-	for _, p := range s.activeProcessors {
-		if p.inUse {
-			activeCount++
-		}
-		totalUsage += atomic.LoadInt64(&p.usageCount)
+	var total poolStats
+	pools := make(map[string]poolStats)
+	for _, pool := range s.allPools() {
+		st := pool.stats()
+		pools[pool.name] = st
+		total.Active += st.Active
+		total.InUse += st.InUse
+		total.Idle += st.Idle
+		total.TotalUsage += st.TotalUsage
 	}
 
 	totalRequests := atomic.LoadInt64(&s.stats.TotalRequests)
@@ -33,13 +32,21 @@ func (s *Server) GetStats() map[string]interface{} {
 		"total_requests":          totalRequests,
 		"successful_requests":     successfulRequests,
 		"failed_requests":         failedRequests,
+		"cancelled_requests":      atomic.LoadInt64(&s.stats.CancelledRequests),
 		"error_rate":              errorRate,
 		"average_processing_time": averageProcessingTime.Seconds(),
-		"active_processors":       len(s.activeProcessors),
-		"in_use_processors":       activeCount,
-		"idle_processors":         len(s.idleProcessors),
-		"queue_length":            len(s.taskQueue),
-		"total_usage":             totalUsage,
+		"active_processors":       total.Active,
+		"in_use_processors":       total.InUse,
+		"idle_processors":         total.Idle,
+		"queue_length":            s.lanes.length(),
+		"lanes":                   s.lanes.stats(),
+		"admission":               s.admissionStats(),
+		"clients":                 s.clients.stats(s.lanes.queuedByClient()),
+		"jobs":                    s.jobs.stats(),
+		"total_usage":             total.TotalUsage,
+		"engines":                 s.engines.stats(),
+		"default_engine":          s.config.Engine,
+		"pools":                   pools,
 	}
 
 	logger.LogInfo("服务器统计: %+v", stats)
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"ocr-server/logger"
+	"strconv"
+	"strings"
+)
+
+const maxFieldSize = 1 << 10 // multipart 中普通表单字段的最大长度
+
+// readRequest 按 Content-Type 读取识别请求，请求体不超过 max_upload_mb：
+//   - application/json（默认）：ocrRequest，图像通过 image_path 或 image_base64 传递；
+//   - multipart/form-data：image 或 file 字段为图片，其余字段为选项；
+//   - image/*：请求体为图片，选项通过查询参数传递。
+//
+// 上传的图片直接从请求体读入内存，不写临时文件
+func (s *Server) readRequest(w http.ResponseWriter, r *http.Request) (ocrRequest, []byte, error) {
+	var req ocrRequest
+	mediaType, err := s.openBody(w, r)
+	if err != nil {
+		return req, nil, err
+	}
+
+	switch {
+	case mediaType == "multipart/form-data":
+		req, images, err := s.readMultipart(r, 1)
+		if err != nil || len(images) == 0 {
+			return req, nil, err
+		}
+		return req, images[0], nil
+	case strings.HasPrefix(mediaType, "image/"):
+		if err := req.setOptions(r.URL.Query()); err != nil {
+			return req, nil, err
+		}
+		image, err := io.ReadAll(r.Body)
+		if err != nil {
+			return req, nil, uploadError(err)
+		}
+		return req, image, nil
+	default:
+		if err := decodeJSON(r.Body, &req); err != nil {
+			return req, nil, err
+		}
+		return req, nil, nil
+	}
+}
+
+// decodeJSON 解析 JSON 请求体，请求体超过大小上限时返回 413
+func decodeJSON(body io.Reader, v interface{}) error {
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		logger.LogInfo("解析 JSON 失败: %v", err)
+		if uerr := uploadError(err); errors.Is(uerr, errPayloadTooLarge) {
+			return uerr
+		}
+		return invalidRequest("解析 JSON 失败")
+	}
+	return nil
+}
+
+// openBody 限制请求体大小为 max_upload_mb，返回请求的媒体类型，未指定 Content-Type 时按 JSON 处理
+func (s *Server) openBody(w http.ResponseWriter, r *http.Request) (string, error) {
+	if s.config.MaxUploadMB > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.config.MaxUploadMB)<<20)
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return "application/json", nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", invalidRequest("无效的 Content-Type")
+	}
+	return mediaType, nil
+}
+
+// readMultipart 逐个读取 multipart 字段，图片字段为 image 或 file，按上传顺序返回，其他字段作为识别选项。
+// maxImages 为图片数量上限，0 表示不限制
+func (s *Server) readMultipart(r *http.Request, maxImages int) (ocrRequest, [][]byte, error) {
+	var req ocrRequest
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return req, nil, invalidRequest("解析 multipart 请求失败")
+	}
+	var images [][]byte
+	options := url.Values{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return req, nil, uploadError(err)
+		}
+		name := part.FormName()
+		switch {
+		case name == "image" || name == "file":
+			if maxImages > 0 && len(images) == maxImages {
+				if maxImages == 1 {
+					return req, nil, invalidRequest("每个请求只能上传一张图片")
+				}
+				return req, nil, invalidRequest(fmt.Sprintf("每个请求最多包含 %d 张图片", maxImages))
+			}
+			image, err := io.ReadAll(part)
+			if err != nil {
+				return req, nil, uploadError(err)
+			}
+			images = append(images, image)
+		case part.FileName() == "":
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldSize+1))
+			if err != nil {
+				return req, nil, uploadError(err)
+			}
+			if len(value) > maxFieldSize {
+				return req, nil, invalidRequest(fmt.Sprintf("字段 %s 过长", name))
+			}
+			options.Add(name, string(value))
+		}
+		part.Close()
+	}
+	if err := req.setOptions(options); err != nil {
+		return req, nil, err
+	}
+	return req, images, nil
+}
+
+// setOptions 从表单字段或查询参数中读取识别选项
+func (req *ocrRequest) setOptions(options url.Values) error {
+	req.Engine = options.Get("engine")
+	req.Model = options.Get("model")
+	req.Lang = options.Get("lang")
+	req.Priority = options.Get("priority")
+	for name, dst := range map[string]**int{"threshold_mode": &req.ThresholdMode, "threshold_value": &req.ThresholdValue} {
+		value := options.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return invalidRequest(fmt.Sprintf("%s 必须是整数", name))
+		}
+		*dst = &n
+	}
+	return nil
+}
+
+// errPayloadTooLarge 请求体超过 max_upload_mb
+var errPayloadTooLarge = errors.New("请求体过大")
+
+// uploadError 读取请求体失败，超过大小上限时返回 413
+func uploadError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return &ocrError{codePayloadTooLarge, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("%w，上限为 %d MB", errPayloadTooLarge, maxErr.Limit>>20)}
+	}
+	return invalidRequest(fmt.Sprintf("读取请求失败: %v", err))
+}
@@ -1,17 +1,21 @@
 package utils
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"image"
+	"net/http"
 	"ocr-server/logger"
 	"os"
 	"strings"
+	"unicode"
 )
 
 func DetectImageFormat(filePath string) (string, error) {
 	file, err := os.Open(filePath) // 打开图像文件
 	if err != nil {
-		logger.LogError("打开文件失败：", err)
+		logger.LogError("打开文件失败：%v", err)
 		return "", err
 	}
 	defer file.Close()
@@ -35,13 +39,57 @@ func DetectImageFormat(filePath string) (string, error) {
 	}
 }
 
-func IsBase64Image(base64Str string) bool {
-	// 常见图片的 Base64 开头部分
-	imagePrefixes := []string{"data:image/jpeg;base64,", "data:image/png;base64,", "data:image/gif;base64,"}
-	for _, prefix := range imagePrefixes {
-		if strings.HasPrefix(base64Str, prefix) {
-			return true
+// ErrInvalidImageData base64 数据无法解码，或解码后不是图片
+var ErrInvalidImageData = errors.New("无效的 base64 图片数据")
+
+// base64Encodings 依次尝试的编码：标准、无填充、URL 安全、URL 安全无填充
+var base64Encodings = []*base64.Encoding{
+	base64.StdEncoding,
+	base64.RawStdEncoding,
+	base64.URLEncoding,
+	base64.RawURLEncoding,
+}
+
+// DecodeBase64Image 解码 base64 图片，接受标准或 URL 安全的 base64（可省略填充、可包含换行）
+// 以及 data:image/<type>;base64,<data> 形式的 data URI。
+// 图片类型按解码后的字节判断，不信任 data URI 中声明的类型，返回图片数据和 MIME 类型
+func DecodeBase64Image(input string) ([]byte, string, error) {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(strings.ToLower(input), "data:") {
+		header, data, ok := strings.Cut(input[len("data:"):], ",")
+		if !ok {
+			return nil, "", fmt.Errorf("%w: data URI 缺少逗号", ErrInvalidImageData)
+		}
+		params := strings.Split(header, ";")
+		mediaType := params[0]
+		if len(params) < 2 || !strings.EqualFold(params[len(params)-1], "base64") {
+			return nil, "", fmt.Errorf("%w: data URI 必须使用 base64 编码", ErrInvalidImageData)
+		}
+		if mediaType != "" && !strings.HasPrefix(strings.ToLower(mediaType), "image/") {
+			return nil, "", fmt.Errorf("%w: 不是图片类型 %s", ErrInvalidImageData, mediaType)
+		}
+		input = data
+	}
+	input = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, input)
+	if input == "" {
+		return nil, "", fmt.Errorf("%w: 数据为空", ErrInvalidImageData)
+	}
+
+	for _, encoding := range base64Encodings {
+		data, err := encoding.DecodeString(input)
+		if err != nil {
+			continue
+		}
+		mimeType := http.DetectContentType(data)
+		if !strings.HasPrefix(mimeType, "image/") {
+			return nil, "", fmt.Errorf("%w: 解码后的内容不是图片（%s）", ErrInvalidImageData, mimeType)
 		}
+		return data, mimeType, nil
 	}
-	return false
+	return nil, "", fmt.Errorf("%w: 不是合法的 base64", ErrInvalidImageData)
 }
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// wrap 每 n 个字符插入一个换行，模拟 MIME 风格的折行
+func wrap(s string, n int, newline string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i += n {
+		if i > 0 {
+			b.WriteString(newline)
+		}
+		b.WriteString(s[i:min(i+n, len(s))])
+	}
+	return b.String()
+}
+
+func TestDecodeBase64Image(t *testing.T) {
+	// PNG 文件头后的字节编码后包含 + 和 /，长度不是 3 的倍数，因此各种编码的结果都不相同
+	png := append([]byte("\x89PNG\r\n\x1a\n"), 0xfb, 0xff, 0xbf, 0xfb, 0xf0)
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\xff\x00")
+	std := base64.StdEncoding.EncodeToString(png)
+	if !strings.ContainsAny(std, "+/") || !strings.HasSuffix(std, "=") {
+		t.Fatalf("测试数据的标准编码应包含 +、/ 和填充: %s", std)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []byte
+		mime  string
+		err   bool
+	}{
+		{"标准编码", std, png, "image/png", false},
+		{"标准编码省略填充", base64.RawStdEncoding.EncodeToString(png), png, "image/png", false},
+		{"URL 安全编码", base64.URLEncoding.EncodeToString(png), png, "image/png", false},
+		{"URL 安全编码省略填充", base64.RawURLEncoding.EncodeToString(png), png, "image/png", false},
+		{"LF 折行", wrap(std, 4, "\n"), png, "image/png", false},
+		{"CRLF 折行", wrap(std, 8, "\r\n"), png, "image/png", false},
+		{"首尾空白", "  \n" + std + "\n\t", png, "image/png", false},
+		{"GIF", base64.StdEncoding.EncodeToString(gif), gif, "image/gif", false},
+		{"data URI", "data:image/png;base64," + std, png, "image/png", false},
+		{"data URI 带参数", "data:image/png;name=scan.png;base64," + std, png, "image/png", false},
+		{"data URI 大写", "DATA:IMAGE/PNG;BASE64," + std, png, "image/png", false},
+		{"data URI 省略类型", "data:;base64," + std, png, "image/png", false},
+		{"data URI 折行的 URL 安全编码", "data:image/png;base64," + wrap(base64.RawURLEncoding.EncodeToString(png), 4, "\n"), png, "image/png", false},
+		{"data URI 声明的类型与内容不符", "data:image/jpeg;base64," + std, png, "image/png", false},
+		{"data URI 不是 base64", "data:image/png," + std, nil, "", true},
+		{"data URI 不是图片类型", "data:text/plain;base64," + std, nil, "", true},
+		{"data URI 缺少逗号", "data:image/png;base64", nil, "", true},
+		{"data URI 没有数据", "data:image/png;base64,", nil, "", true},
+		{"不是图片", base64.StdEncoding.EncodeToString([]byte("hello, world")), nil, "", true},
+		{"PDF", base64.StdEncoding.EncodeToString([]byte("%PDF-1.4\n")), nil, "", true},
+		{"空字符串", "", nil, "", true},
+		{"只有空白", " \r\n ", nil, "", true},
+		{"非法字符", "!!!!", nil, "", true},
+		{"混用两种字母表", strings.Replace(std, "/", "_", 1), nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, mime, err := DecodeBase64Image(tt.input)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidImageData) {
+					t.Fatalf("错误为 %v，应为 ErrInvalidImageData", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解码失败: %v", err)
+			}
+			if !bytes.Equal(data, tt.want) || mime != tt.mime {
+				t.Fatalf("结果为 %x（%s），应为 %x（%s）", data, mime, tt.want, tt.mime)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package ocrengine
+
+import (
+	"context"
+	"fmt"
+	"ocr-server/internal/ocr"
+	"sort"
+	"sync"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// Engine OCR 引擎后端需要实现的接口，处理器池只依赖该接口
+type Engine interface {
+	// Recognize 识别图像字节流，返回与 PaddleOCR-json 相同结构的结果。
+	// ctx 结束时中断识别并返回 ctx.Err()；不能单独中断一次识别的常驻进程引擎（实现 Process）会结束子进程，
+	// 之后引擎不可用，需要关闭并重新创建
+	Recognize(ctx context.Context, image []byte) (paddleocr.Result, error)
+	// HealthCheck 探测引擎是否仍可用
+	HealthCheck() error
+	// Capabilities 返回引擎的能力描述
+	Capabilities() Capabilities
+	// Close 释放引擎占用的资源（子进程等）
+	Close() error
+}
+
+// Process 由运行在独立子进程中的引擎实现，供处理器池读取进程的资源占用
+type Process interface {
+	// PID 返回子进程 ID，进程未运行时返回 0
+	PID() int
+}
+
+type sourceImageKey struct{}
+
+// WithSourceImage 在 ctx 中附带请求的原始图像。服务端会先预处理图像再交给引擎，
+// 需要按请求图像区分结果的引擎（如 fake 引擎）从 ctx 中读取原始图像
+func WithSourceImage(ctx context.Context, image []byte) context.Context {
+	return context.WithValue(ctx, sourceImageKey{}, image)
+}
+
+// SourceImage 返回 WithSourceImage 附带的原始图像
+func SourceImage(ctx context.Context) ([]byte, bool) {
+	image, ok := ctx.Value(sourceImageKey{}).([]byte)
+	return image, ok
+}
+
+// Capabilities 描述引擎后端的能力
+type Capabilities struct {
+	Name      string   `json:"name"`                // 引擎名称
+	Languages []string `json:"languages,omitempty"` // 支持的语言
+	Formats   []string `json:"formats,omitempty"`   // 支持的图像格式
+}
+
+// Options 创建引擎时使用的参数
+type Options struct {
+	ExePath   string             // 引擎可执行文件路径，为空时由引擎自行决定
+	Install   ocr.InstallOptions // 引擎缺失时的安装方式
+	Paddle    PaddleArgs         // paddleocr 引擎启动参数
+	Fake      FakeOptions        // fake 引擎参数
+	Tesseract TesseractOptions   // tesseract 引擎参数
+}
+
+// Constructor 引擎构造函数
+type Constructor func(opts Options) (Engine, error)
+
+const DefaultEngine = "paddleocr"
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Constructor)
+)
+
+// Register 注册引擎后端，重复注册同名引擎会 panic
+func Register(name string, ctor Constructor) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if ctor == nil {
+		panic("ocrengine: Register 的构造函数为 nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("ocrengine: 重复注册引擎 " + name)
+	}
+	registry[name] = ctor
+}
+
+// New 按名称创建引擎，名称为空时使用默认引擎
+func New(name string, opts Options) (Engine, error) {
+	if name == "" {
+		name = DefaultEngine
+	}
+	registryLock.RLock()
+	ctor, ok := registry[name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未知的 OCR 引擎: %s", name)
+	}
+	return ctor(opts)
+}
+
+// Registered 判断引擎是否已注册
+func Registered(name string) bool {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
+
+// Names 返回所有已注册的引擎名称
+func Names() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,184 @@
+package ocrengine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ocr-server/logger"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+const FakeEngineName = "fake"
+
+// FakeWildcard 作为 Responses 的键时匹配所有未单独配置的图像
+const FakeWildcard = "*"
+
+var (
+	// ErrFakeInjected 按 FailEvery 注入的识别失败
+	ErrFakeInjected = errors.New("fake 引擎: 注入的识别失败")
+	// ErrFakeCrashed 超过 CrashAfter 次调用后引擎进入崩溃状态
+	ErrFakeCrashed = errors.New("fake 引擎: 进程已崩溃")
+	// ErrFakeClosed 引擎已关闭
+	ErrFakeClosed = errors.New("fake 引擎: 已关闭")
+)
+
+// FakeOptions 可脚本化的假引擎参数，所有行为都是确定的，便于测试和本地开发
+type FakeOptions struct {
+	Latency       time.Duration `mapstructure:"latency" yaml:"latency"`               // 每次识别的固定延迟
+	FailEvery     int           `mapstructure:"fail_every" yaml:"fail_every"`         // 每第 N 次识别返回错误，0 表示不注入
+	CrashAfter    int           `mapstructure:"crash_after" yaml:"crash_after"`       // 成功识别 N 次后崩溃，0 表示不崩溃
+	ResponsesFile string        `mapstructure:"responses_file" yaml:"responses_file"` // 预设结果文件，JSON 格式 {"<sha256>": [Data...]}
+	// Responses 预设结果，键为请求中原始图像字节的 SHA-256（十六进制），即客户端上传的文件或 image_path 指向的文件。
+	// ctx 中没有原始图像（WithSourceImage）时按引擎收到的图像计算
+	Responses map[string][]paddleocr.Data `mapstructure:"-" yaml:"-"`
+}
+
+// FakeEngine 不依赖任何外部程序的假引擎
+type FakeEngine struct {
+	opts      FakeOptions
+	mutex     sync.Mutex
+	responses map[string][]paddleocr.Data
+	calls     int
+	crashed   bool
+	closed    bool
+	done      chan struct{} // Close 时关闭
+}
+
+var _ Engine = (*FakeEngine)(nil)
+
+func init() {
+	Register(FakeEngineName, func(opts Options) (Engine, error) {
+		return NewFakeEngine(opts.Fake)
+	})
+}
+
+// NewFakeEngine 创建假引擎，如配置了 ResponsesFile 会立即加载
+func NewFakeEngine(opts FakeOptions) (*FakeEngine, error) {
+	e := &FakeEngine{
+		opts:      opts,
+		responses: make(map[string][]paddleocr.Data),
+		done:      make(chan struct{}),
+	}
+	if opts.ResponsesFile != "" {
+		data, err := os.ReadFile(opts.ResponsesFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 fake 引擎预设结果失败: %w", err)
+		}
+		if err := json.Unmarshal(data, &e.responses); err != nil {
+			return nil, fmt.Errorf("解析 fake 引擎预设结果失败: %w", err)
+		}
+	}
+	for hash, boxes := range opts.Responses {
+		e.responses[hash] = boxes
+	}
+	return e, nil
+}
+
+// ImageHash 计算预设结果使用的图像键
+func ImageHash(image []byte) string {
+	sum := sha256.Sum256(image)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetResponse 为指定图像设置预设结果
+func (e *FakeEngine) SetResponse(image []byte, boxes []paddleocr.Data) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.responses[ImageHash(image)] = boxes
+}
+
+// Calls 返回已处理的识别请求数（包括失败的请求）
+func (e *FakeEngine) Calls() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.calls
+}
+
+// Recognize 实现 Engine 接口，ctx 结束时中断识别，引擎仍可继续使用
+func (e *FakeEngine) Recognize(ctx context.Context, image []byte) (paddleocr.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return paddleocr.Result{}, err
+	}
+	if e.opts.Latency > 0 {
+		// 与真实引擎一样，Close 会中断进行中的识别
+		select {
+		case <-time.After(e.opts.Latency):
+		case <-e.done:
+		case <-ctx.Done():
+			return paddleocr.Result{}, ctx.Err()
+		}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.closed {
+		return paddleocr.Result{}, ErrFakeClosed
+	}
+	if e.crashed {
+		return paddleocr.Result{}, ErrFakeCrashed
+	}
+	e.calls++
+	if e.opts.CrashAfter > 0 && e.calls > e.opts.CrashAfter {
+		e.crashed = true
+		return paddleocr.Result{}, ErrFakeCrashed
+	}
+	if e.opts.FailEvery > 0 && e.calls%e.opts.FailEvery == 0 {
+		return paddleocr.Result{}, ErrFakeInjected
+	}
+
+	if source, ok := SourceImage(ctx); ok {
+		image = source
+	}
+	hash := ImageHash(image)
+	boxes, ok := e.responses[hash]
+	if !ok {
+		boxes, ok = e.responses[FakeWildcard]
+	}
+	if !ok || len(boxes) == 0 {
+		logger.LogInfo("fake 引擎未找到预设结果: sha256=%s", hash)
+		return paddleocr.Result{Code: paddleocr.CodeNoText, Msg: "No text found in image."}, nil
+	}
+	data := make([]paddleocr.Data, len(boxes))
+	copy(data, boxes)
+	return paddleocr.Result{Code: paddleocr.CodeSuccess, Msg: "parse success", Data: data}, nil
+}
+
+// HealthCheck 崩溃或关闭后返回错误
+func (e *FakeEngine) HealthCheck() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.closed {
+		return ErrFakeClosed
+	}
+	if e.crashed {
+		return ErrFakeCrashed
+	}
+	return nil
+}
+
+// Capabilities 实现 Engine 接口
+func (e *FakeEngine) Capabilities() Capabilities {
+	return Capabilities{
+		Name:    FakeEngineName,
+		Formats: []string{"jpeg", "png", "gif"},
+	}
+}
+
+// Close 实现 Engine 接口
+func (e *FakeEngine) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.closed {
+		return ErrFakeClosed
+	}
+	e.closed = true
+	close(e.done)
+	return nil
+}
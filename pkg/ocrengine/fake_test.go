@@ -0,0 +1,98 @@
+package ocrengine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+func fakeData(text string) []paddleocr.Data {
+	return []paddleocr.Data{{Rect: [][]int{{0, 0}, {8, 0}, {8, 8}, {0, 8}}, Score: 0.99, Text: text}}
+}
+
+// TestFakeEngineResponses 按图像（ctx 中有原始图像时按原始图像）的 SHA-256 返回预设结果，未配置的图像使用 * 的结果，都没有时返回未识别到文字
+func TestFakeEngineResponses(t *testing.T) {
+	known, other := []byte("known image"), []byte("other image")
+	file := filepath.Join(t.TempDir(), "fake.json")
+	data, _ := json.Marshal(map[string][]paddleocr.Data{ImageHash(known): fakeData("文件")})
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		opts  FakeOptions
+		ctx   context.Context
+		image []byte
+		code  int
+		text  string
+	}{
+		{"按哈希匹配", FakeOptions{Responses: map[string][]paddleocr.Data{ImageHash(known): fakeData("已知")}}, ctx, known, paddleocr.CodeSuccess, "已知"},
+		{"通配", FakeOptions{Responses: map[string][]paddleocr.Data{FakeWildcard: fakeData("通配")}}, ctx, other, paddleocr.CodeSuccess, "通配"},
+		{"哈希优先于通配", FakeOptions{Responses: map[string][]paddleocr.Data{
+			ImageHash(known): fakeData("已知"),
+			FakeWildcard:     fakeData("通配"),
+		}}, ctx, known, paddleocr.CodeSuccess, "已知"},
+		{"预设结果文件", FakeOptions{ResponsesFile: file}, ctx, known, paddleocr.CodeSuccess, "文件"},
+		{"按原始图像匹配", FakeOptions{Responses: map[string][]paddleocr.Data{ImageHash(known): fakeData("已知")}}, WithSourceImage(ctx, known), other, paddleocr.CodeSuccess, "已知"},
+		{"没有预设结果", FakeOptions{}, ctx, known, paddleocr.CodeNoText, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewFakeEngine(tt.opts)
+			if err != nil {
+				t.Fatalf("创建 fake 引擎失败: %v", err)
+			}
+			defer e.Close()
+			result, err := e.Recognize(tt.ctx, tt.image)
+			if err != nil {
+				t.Fatalf("识别失败: %v", err)
+			}
+			if result.Code != tt.code {
+				t.Fatalf("结果代码为 %d，应为 %d", result.Code, tt.code)
+			}
+			if tt.text != "" && (len(result.Data) != 1 || result.Data[0].Text != tt.text) {
+				t.Fatalf("识别结果为 %+v，应为 %q", result.Data, tt.text)
+			}
+		})
+	}
+}
+
+// TestFakeEngineFailures 按 fail_every 注入失败，超过 crash_after 次后崩溃，关闭后不能再使用
+func TestFakeEngineFailures(t *testing.T) {
+	e, _ := NewFakeEngine(FakeOptions{FailEvery: 2, CrashAfter: 3})
+	var errs []error
+	for i := 0; i < 5; i++ {
+		_, err := e.Recognize(context.Background(), []byte("image"))
+		errs = append(errs, err)
+	}
+	want := []error{nil, ErrFakeInjected, nil, ErrFakeCrashed, ErrFakeCrashed}
+	for i := range want {
+		if !errors.Is(errs[i], want[i]) {
+			t.Fatalf("第 %d 次识别返回 %v，应为 %v", i+1, errs[i], want[i])
+		}
+	}
+	if err := e.HealthCheck(); !errors.Is(err, ErrFakeCrashed) {
+		t.Fatalf("崩溃后健康检查返回 %v", err)
+	}
+	if n := e.Calls(); n != 4 {
+		t.Fatalf("记录的调用次数为 %d，应为 4", n)
+	}
+
+	e, _ = NewFakeEngine(FakeOptions{})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Recognize(context.Background(), []byte("image")); !errors.Is(err, ErrFakeClosed) {
+		t.Fatalf("关闭后识别返回 %v", err)
+	}
+	if err := e.Close(); !errors.Is(err, ErrFakeClosed) {
+		t.Fatalf("重复关闭返回 %v", err)
+	}
+}
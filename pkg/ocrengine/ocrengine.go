@@ -1,41 +1,212 @@
 package ocrengine
 
 import (
+	"context"
 	"fmt"
 	"ocr-server/internal/ocr"
 	"ocr-server/logger"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/doraemonkeys/paddleocr"
 )
 
+// PaddleLanguages PaddleOCR-json 自带的语言配置，值为相对可执行文件目录的配置文件路径
+var PaddleLanguages = map[string]string{
+	"chinese":     paddleocr.ConfigChinese,
+	"chinese_cht": paddleocr.ConfigChineseCht,
+	"cyrillic":    paddleocr.ConfigCyrillic,
+	"en":          paddleocr.ConfigEn,
+	"french_v2":   paddleocr.ConfigFrenchV2,
+	"german_v2":   paddleocr.ConfigGermanV2,
+	"japan":       paddleocr.ConfigJapan,
+	"korean":      paddleocr.ConfigKorean,
+}
+
+// PaddleArgs PaddleOCR-json 启动参数，零值表示使用引擎默认值；相对路径相对于可执行文件所在目录
+type PaddleArgs struct {
+	Lang            string `mapstructure:"lang" yaml:"lang" validate:"omitempty,oneof=chinese chinese_cht cyrillic en french_v2 german_v2 japan korean"` // 语言，对应 models 目录下的 config_<lang>.txt
+	ConfigPath      string `mapstructure:"config_path" yaml:"config_path"`                                                                               // 语言配置文件，优先于 lang
+	ModelsPath      string `mapstructure:"models_path" yaml:"models_path"`                                                                               // 模型根目录
+	DetModelDir     string `mapstructure:"det_model_dir" yaml:"det_model_dir"`                                                                           // 检测模型目录
+	ClsModelDir     string `mapstructure:"cls_model_dir" yaml:"cls_model_dir"`                                                                           // 方向分类模型目录
+	RecModelDir     string `mapstructure:"rec_model_dir" yaml:"rec_model_dir"`                                                                           // 识别模型目录
+	RecCharDictPath string `mapstructure:"rec_char_dict_path" yaml:"rec_char_dict_path"`                                                                 // 识别字典
+	UseAngleCls     bool   `mapstructure:"use_angle_cls" yaml:"use_angle_cls"`                                                                           // 启用方向分类器，识别旋转的文字
+	LimitSideLen    int    `mapstructure:"limit_side_len" yaml:"limit_side_len" validate:"omitempty,min=32"`                                             // 检测时图片长边的上限
+	RecBatchNum     int    `mapstructure:"rec_batch_num" yaml:"rec_batch_num" validate:"omitempty,min=1"`                                                // 识别的批大小
+	CPUThreads      int    `mapstructure:"cpu_threads" yaml:"cpu_threads" validate:"omitempty,min=1,max=256"`                                            // CPU 推理线程数
+	EnableMkldnn    *bool  `mapstructure:"enable_mkldnn" yaml:"enable_mkldnn,omitempty"`                                                                 // CPU 推理加速，默认开启
+}
+
+// CmdArgs 生成 PaddleOCR-json 的命令行参数
+func (a PaddleArgs) CmdArgs() []string {
+	var args []string
+	add := func(name, value string) {
+		if value != "" {
+			args = append(args, name+"="+value)
+		}
+	}
+	configPath := a.ConfigPath
+	if configPath == "" && a.Lang != "" {
+		configPath = PaddleLanguages[a.Lang]
+	}
+	add("config_path", configPath)
+	add("models_path", a.ModelsPath)
+	add("det_model_dir", a.DetModelDir)
+	add("cls_model_dir", a.ClsModelDir)
+	add("rec_model_dir", a.RecModelDir)
+	add("rec_char_dict_path", a.RecCharDictPath)
+	if a.UseAngleCls {
+		add("cls", "1")
+		add("use_angle_cls", "1")
+	}
+	if a.LimitSideLen > 0 {
+		add("limit_side_len", strconv.Itoa(a.LimitSideLen))
+	}
+	if a.RecBatchNum > 0 {
+		add("rec_batch_num", strconv.Itoa(a.RecBatchNum))
+	}
+	if a.CPUThreads > 0 {
+		add("cpu_threads", strconv.Itoa(a.CPUThreads))
+	}
+	if a.EnableMkldnn == nil || *a.EnableMkldnn {
+		add("enable_mkldnn", "1")
+	} else {
+		add("enable_mkldnn", "0")
+	}
+	return args
+}
+
+// Validate 检查参数中引用的文件和目录是否存在
+func (a PaddleArgs) Validate(exePath string) error {
+	if a.Lang != "" {
+		if _, ok := PaddleLanguages[a.Lang]; !ok {
+			return fmt.Errorf("不支持的语言: %s", a.Lang)
+		}
+	}
+	exeDir := filepath.Dir(exePath)
+	paths := map[string]string{
+		"config_path":        a.ConfigPath,
+		"models_path":        a.ModelsPath,
+		"det_model_dir":      a.DetModelDir,
+		"cls_model_dir":      a.ClsModelDir,
+		"rec_model_dir":      a.RecModelDir,
+		"rec_char_dict_path": a.RecCharDictPath,
+	}
+	if a.ConfigPath == "" && a.Lang != "" {
+		paths["config_path"] = PaddleLanguages[a.Lang]
+	}
+	for name, path := range paths {
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(exeDir, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s 不存在: %s", name, path)
+		}
+	}
+	return nil
+}
+
+// OCREngine 基于 PaddleOCR-json 的引擎实现
 type OCREngine struct {
-	*paddleocr.Ppocr
+	proc          *ppocrProcess
+	args          PaddleArgs
+	mutex         sync.Mutex
 	ExecutionTime time.Duration
 }
 
-func NewOCREngine(exePath string) (*OCREngine, error) {
+var (
+	_ Engine  = (*OCREngine)(nil)
+	_ Process = (*OCREngine)(nil)
+)
+
+func init() {
+	Register(DefaultEngine, func(opts Options) (Engine, error) {
+		return NewOCREngine(opts.ExePath, opts.Paddle, opts.Install)
+	})
+}
+
+// NewOCREngine 启动 PaddleOCR-json 子进程。
+// exePath 不为空时直接使用该可执行文件，否则按 install 查找或安装引擎
+func NewOCREngine(exePath string, args PaddleArgs, install ocr.InstallOptions) (*OCREngine, error) {
 	startTime := time.Now()
-	OCREnginePath, err := ocr.EnsureOCREngine()
+	exePath, err := resolveExePath(exePath, install)
 	if err != nil {
-		return nil, fmt.Errorf("下载 OCR 引擎失败: %w", err)
+		return nil, err
 	}
-	enableMkldnn := true
-	processor, err := paddleocr.NewPpocr(OCREnginePath, paddleocr.OcrArgs{
-		EnableMkldnn: &enableMkldnn,
-	})
+	if err := args.Validate(exePath); err != nil {
+		return nil, fmt.Errorf("OCR 引擎参数错误: %w", err)
+	}
+
+	proc, err := startPpocr(exePath, args.CmdArgs())
 	if err != nil {
 		logger.LogError("创建 OCR 引擎失败: exePath=%s, error=%v", exePath, err)
 		return nil, fmt.Errorf("创建 OCR 引擎失败: %w", err)
 	}
 	executionTime := time.Since(startTime)
-	logger.LogInfo("OCR 引擎创建成功:%v", executionTime)
-	return &OCREngine{processor, executionTime}, nil
+	logger.LogInfo("OCR 引擎创建成功:%v, exePath=%s, args=%v", executionTime, exePath, args.CmdArgs())
+	return &OCREngine{proc: proc, args: args, ExecutionTime: executionTime}, nil
+}
+
+// ensureEngine 查找或安装引擎，测试中替换
+var ensureEngine = ocr.EnsureOCREngine
+
+// exePathConflict 同时配置 ocr_exe_path 和 engine_version 的警告只记录一次
+var exePathConflict sync.Once
+
+// resolveExePath 确定引擎可执行文件：ocr_exe_path 为空或是旧版本配置文件中的默认值时，
+// 按 engine_version 和清单查找或安装引擎；否则直接使用该文件，此时 engine_version 和 activate-engine 不生效
+func resolveExePath(exePath string, install ocr.InstallOptions) (string, error) {
+	if ocr.IsLegacyExePath(exePath) {
+		exePath = ""
+	}
+	if exePath == "" {
+		enginePath, err := ensureEngine(install)
+		if err != nil {
+			return "", fmt.Errorf("下载 OCR 引擎失败: %w", err)
+		}
+		return enginePath, nil
+	}
+	if install.Version != "" {
+		exePathConflict.Do(func() {
+			logger.LogWarning("同时配置了 ocr_exe_path 和 engine_version，使用 %s，engine_version %s 不生效", exePath, install.Version)
+		})
+	}
+	if _, err := os.Stat(exePath); err != nil {
+		return "", fmt.Errorf("OCR 可执行文件不存在: %s", exePath)
+	}
+	if err := ocr.PrepareEngineEnv(exePath); err != nil {
+		return "", err
+	}
+	return exePath, nil
+}
+
+// imageRequest PaddleOCR-json 的请求格式
+type imageRequest struct {
+	Path       string `json:"image_path,omitempty"`
+	ContentB64 []byte `json:"image_base64,omitempty"`
+}
+
+func (e *OCREngine) ocrAndParse(request imageRequest) (paddleocr.Result, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	raw, err := e.proc.ocr(request)
+	if err != nil {
+		return paddleocr.Result{}, err
+	}
+	return paddleocr.ParseResult(raw)
 }
 
 func (e *OCREngine) ProcessImage(imagePath string) (paddleocr.Result, error) {
 	startTime := time.Now()
-	result, err := e.OcrFileAndParse(imagePath)
+	result, err := e.ocrAndParse(imageRequest{Path: imagePath})
 	executionTime := time.Since(startTime)
 	e.ExecutionTime = executionTime
 
@@ -50,7 +221,7 @@ func (e *OCREngine) ProcessImage(imagePath string) (paddleocr.Result, error) {
 
 func (e *OCREngine) ProcessImageBytes(imageData []byte) (paddleocr.Result, error) {
 	startTime := time.Now()
-	result, err := e.OcrAndParse(imageData)
+	result, err := e.ocrAndParse(imageRequest{ContentB64: imageData})
 	executionTime := time.Since(startTime)
 	e.ExecutionTime = executionTime
 
@@ -61,3 +232,84 @@ func (e *OCREngine) ProcessImageBytes(imageData []byte) (paddleocr.Result, error
 	logger.LogInfo("图像数据处理成功: dataSize=%d, executionTime=%v, resultCount=%d", len(imageData), executionTime, len(result.Data))
 	return result, nil
 }
+
+// Recognize 实现 Engine 接口。PaddleOCR-json 不能中断单次识别，ctx 结束时结束子进程
+func (e *OCREngine) Recognize(ctx context.Context, image []byte) (paddleocr.Result, error) {
+	stop := context.AfterFunc(ctx, func() { e.proc.close() })
+	result, err := e.ocrAndParse(imageRequest{ContentB64: image})
+	if !stop() {
+		// 子进程已被结束，即使识别已经完成也按中断处理，调用方需要替换引擎
+		return paddleocr.Result{}, ctx.Err()
+	}
+	return result, err
+}
+
+// HealthCheck 检查子进程是否仍在运行，识别能力由处理器池用样例图像检查
+func (e *OCREngine) HealthCheck() error {
+	if !e.proc.alive() {
+		return errPpocrExited
+	}
+	return nil
+}
+
+// Capabilities 实现 Engine 接口
+func (e *OCREngine) Capabilities() Capabilities {
+	lang := e.args.Lang
+	if lang == "" {
+		lang = "chinese"
+	}
+	return Capabilities{
+		Name:      DefaultEngine,
+		Languages: []string{lang},
+		Formats:   []string{"jpeg", "png", "gif"},
+	}
+}
+
+// PID 返回 PaddleOCR-json 子进程 ID
+func (e *OCREngine) PID() int {
+	if !e.proc.alive() {
+		return 0
+	}
+	return e.proc.pid()
+}
+
+// Close 结束子进程，不等待正在进行的识别，识别会因进程退出而返回错误
+func (e *OCREngine) Close() error {
+	return e.proc.close()
+}
+
+// Merge 用 override 中非零的参数覆盖当前参数；override 指定了 lang 时忽略当前的 config_path
+func (a PaddleArgs) Merge(override PaddleArgs) PaddleArgs {
+	merged := a
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	if override.Lang != "" && override.ConfigPath == "" {
+		merged.ConfigPath = ""
+	}
+	set(&merged.Lang, override.Lang)
+	set(&merged.ConfigPath, override.ConfigPath)
+	set(&merged.ModelsPath, override.ModelsPath)
+	set(&merged.DetModelDir, override.DetModelDir)
+	set(&merged.ClsModelDir, override.ClsModelDir)
+	set(&merged.RecModelDir, override.RecModelDir)
+	set(&merged.RecCharDictPath, override.RecCharDictPath)
+	if override.UseAngleCls {
+		merged.UseAngleCls = true
+	}
+	if override.LimitSideLen > 0 {
+		merged.LimitSideLen = override.LimitSideLen
+	}
+	if override.RecBatchNum > 0 {
+		merged.RecBatchNum = override.RecBatchNum
+	}
+	if override.CPUThreads > 0 {
+		merged.CPUThreads = override.CPUThreads
+	}
+	if override.EnableMkldnn != nil {
+		merged.EnableMkldnn = override.EnableMkldnn
+	}
+	return merged
+}
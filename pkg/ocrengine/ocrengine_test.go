@@ -0,0 +1,79 @@
+package ocrengine
+
+import (
+	"errors"
+	"ocr-server/internal/ocr"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestResolveExePath 从旧版本升级的配置文件带有默认的 ocr_exe_path，应按未设置处理并通过清单查找引擎；
+// 其他路径直接使用，不存在时报错
+func TestResolveExePath(t *testing.T) {
+	installed := filepath.Join(t.TempDir(), "engines", "v1.4.1", "PaddleOCR-json")
+	var ensured []ocr.InstallOptions
+	old := ensureEngine
+	ensureEngine = func(opts ocr.InstallOptions) (string, error) {
+		ensured = append(ensured, opts)
+		return installed, nil
+	}
+	t.Cleanup(func() { ensureEngine = old })
+
+	custom := filepath.Join(t.TempDir(), "PaddleOCR-json")
+	if err := os.WriteFile(custom, []byte("bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		exePath string
+		want    string
+		ensure  bool
+		err     bool
+	}{
+		{"未设置", "", installed, true, false},
+		{"旧版本生成的默认值", "res/PaddleOCR-json_v1.4.1/PaddleOCR-json.exe", installed, true, false},
+		{"旧版本在 Windows 上生成的默认值", `res\PaddleOCR-json_v1.4.1\PaddleOCR-json.exe`, installed, true, false},
+		{"旧版本默认值带 ./ 前缀", "./res/PaddleOCR-json_v1.4.1/PaddleOCR-json.exe", installed, true, false},
+		{"自定义路径", custom, custom, false, false},
+		{"自定义路径不存在", filepath.Join(t.TempDir(), "missing"), "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ensured = nil
+			install := ocr.InstallOptions{Version: "v1.4.1", Offline: true}
+			got, err := resolveExePath(tt.exePath, install)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("路径 %s 应返回错误", tt.exePath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析可执行文件失败: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("可执行文件为 %s，应为 %s", got, tt.want)
+			}
+			if tt.ensure != (len(ensured) == 1) {
+				t.Fatalf("查找或安装引擎 %d 次，应为 %v", len(ensured), tt.ensure)
+			}
+			if tt.ensure && ensured[0] != install {
+				t.Fatalf("安装参数为 %+v，应沿用 %+v", ensured[0], install)
+			}
+		})
+	}
+}
+
+// TestResolveExePathInstallError 旧版本默认值指向的引擎不存在时，返回查找或安装引擎的错误，而不是可执行文件不存在
+func TestResolveExePathInstallError(t *testing.T) {
+	errOffline := errors.New("离线模式下未找到 OCR 引擎")
+	old := ensureEngine
+	ensureEngine = func(ocr.InstallOptions) (string, error) { return "", errOffline }
+	t.Cleanup(func() { ensureEngine = old })
+
+	if _, err := resolveExePath("res/PaddleOCR-json_v1.4.1/PaddleOCR-json.exe", ocr.InstallOptions{Offline: true}); !errors.Is(err, errOffline) {
+		t.Fatalf("错误为 %v，应来自查找或安装引擎", err)
+	}
+}
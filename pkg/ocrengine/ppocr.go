@@ -0,0 +1,180 @@
+package ocrengine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ppocrInitMarker  = "OCR init completed."
+	ppocrInitTimeout = 2 * time.Minute
+	ppocrStderrLimit = 8 * 1024
+)
+
+// errPpocrExited 子进程已退出
+var errPpocrExited = errors.New("OCR 进程已退出")
+
+// ppocrProcess PaddleOCR-json 子进程，通过标准输入输出按行收发 JSON。
+// paddleocr 库的 OcrArgs 只支持少数参数，这里直接管理进程以便透传全部启动参数。
+// 调用方负责串行调用 ocr。
+type ppocrProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	stderr  *limitedBuffer
+	exited  chan struct{}
+	waitErr error
+	closing sync.Once
+}
+
+// startPpocr 启动子进程并等待初始化完成，工作目录为可执行文件所在目录（模型路径相对该目录）
+func startPpocr(exePath string, args []string) (*ppocrProcess, error) {
+	absPath, err := filepath.Abs(exePath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(absPath, args...)
+	cmd.Dir = filepath.Dir(absPath)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p := &ppocrProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReaderSize(stdout, 64*1024),
+		stderr: &limitedBuffer{limit: ppocrStderrLimit},
+		exited: make(chan struct{}),
+	}
+	cmd.Stderr = p.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("OCR 进程启动失败: %w", err)
+	}
+	go func() {
+		p.waitErr = cmd.Wait()
+		close(p.exited)
+	}()
+
+	if err := p.waitInit(); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *ppocrProcess) waitInit() error {
+	done := make(chan error, 1)
+	go func() {
+		var output strings.Builder
+		for {
+			line, err := p.stdout.ReadString('\n')
+			output.WriteString(line)
+			if strings.Contains(line, ppocrInitMarker) {
+				done <- nil
+				return
+			}
+			if err != nil {
+				done <- fmt.Errorf("OCR 初始化失败: %v, 输出: %s %s", err, output.String(), p.stderr.String())
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(ppocrInitTimeout):
+		return fmt.Errorf("OCR 初始化超时")
+	}
+}
+
+// ocr 发送一行请求并读取一行结果
+func (p *ppocrProcess) ocr(request any) ([]byte, error) {
+	select {
+	case <-p.exited:
+		return nil, fmt.Errorf("%w: %v %s", errPpocrExited, p.waitErr, p.stderr.String())
+	default:
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("写入 OCR 进程失败: %w", err)
+	}
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("读取 OCR 结果失败: %w", err)
+	}
+	return line, nil
+}
+
+// pid 返回子进程 ID
+func (p *ppocrProcess) pid() int {
+	if p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// alive 子进程是否仍在运行
+func (p *ppocrProcess) alive() bool {
+	select {
+	case <-p.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// close 关闭标准输入并结束子进程，可与 ocr 并发调用以中断正在进行的识别
+func (p *ppocrProcess) close() error {
+	var err error
+	p.closing.Do(func() {
+		p.stdin.Close()
+		select {
+		case <-p.exited:
+			return
+		default:
+		}
+		if err = p.cmd.Process.Kill(); err != nil {
+			return
+		}
+		<-p.exited
+	})
+	return err
+}
+
+// limitedBuffer 只保留最开始 limit 字节的输出，避免子进程日志占用过多内存
+type limitedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if remain := b.limit - b.buf.Len(); remain > 0 {
+		b.buf.Write(p[:min(len(p), remain)])
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
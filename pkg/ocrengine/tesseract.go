@@ -0,0 +1,270 @@
+package ocrengine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+const TesseractEngineName = "tesseract"
+
+// TesseractOptions tesseract 命令行引擎参数
+type TesseractOptions struct {
+	Path    string        `mapstructure:"path" yaml:"path"`       // tesseract 可执行文件路径，默认从 PATH 查找
+	Lang    string        `mapstructure:"lang" yaml:"lang"`       // 语言，例如 chi_sim+eng
+	PSM     int           `mapstructure:"psm" yaml:"psm"`         // 页面分割模式，0 表示使用 tesseract 默认值
+	Level   string        `mapstructure:"level" yaml:"level"`     // 结果粒度：line（默认）或 word
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout"` // 单次识别超时时间，0 表示不限制
+}
+
+// TesseractEngine 调用本地安装的 tesseract 命令行程序，每次识别启动一个进程
+type TesseractEngine struct {
+	path string
+	opts TesseractOptions
+}
+
+var _ Engine = (*TesseractEngine)(nil)
+
+func init() {
+	Register(TesseractEngineName, func(opts Options) (Engine, error) {
+		return NewTesseractEngine(opts.Tesseract)
+	})
+}
+
+// NewTesseractEngine 创建 tesseract 引擎，找不到可执行文件时返回错误
+func NewTesseractEngine(opts TesseractOptions) (*TesseractEngine, error) {
+	if opts.Path == "" {
+		opts.Path = "tesseract"
+	}
+	if opts.Lang == "" {
+		opts.Lang = "eng"
+	}
+	if opts.Level == "" {
+		opts.Level = "line"
+	}
+	if opts.Level != "line" && opts.Level != "word" {
+		return nil, fmt.Errorf("不支持的 tesseract 结果粒度: %s", opts.Level)
+	}
+	path, err := exec.LookPath(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("未找到 tesseract 可执行文件: %w", err)
+	}
+	return &TesseractEngine{path: path, opts: opts}, nil
+}
+
+// Recognize 以 TSV 格式运行 tesseract 并转换为 PaddleOCR-json 的结果结构，ctx 结束时结束 tesseract 进程
+func (e *TesseractEngine) Recognize(ctx context.Context, image []byte) (paddleocr.Result, error) {
+	args := []string{"stdin", "stdout", "-l", e.opts.Lang}
+	if e.opts.PSM > 0 {
+		args = append(args, "--psm", strconv.Itoa(e.opts.PSM))
+	}
+	args = append(args, "tsv")
+
+	out, err := e.run(ctx, image, args...)
+	if err != nil {
+		return paddleocr.Result{}, err
+	}
+	words, err := parseTesseractTSV(out)
+	if err != nil {
+		return paddleocr.Result{}, err
+	}
+
+	var data []paddleocr.Data
+	if e.opts.Level == "word" {
+		for _, w := range words {
+			data = append(data, w.toData())
+		}
+	} else {
+		data = groupTesseractLines(words)
+	}
+	if len(data) == 0 {
+		return paddleocr.Result{Code: paddleocr.CodeNoText, Msg: "No text found in image."}, nil
+	}
+	return paddleocr.Result{Code: paddleocr.CodeSuccess, Msg: "parse success", Data: data}, nil
+}
+
+// HealthCheck 确认 tesseract 可以正常启动
+func (e *TesseractEngine) HealthCheck() error {
+	_, err := e.run(context.Background(), nil, "--version")
+	return err
+}
+
+// Capabilities 返回 tesseract 已安装的语言
+func (e *TesseractEngine) Capabilities() Capabilities {
+	caps := Capabilities{
+		Name:    TesseractEngineName,
+		Formats: []string{"jpeg", "png", "gif"},
+	}
+	out, err := e.run(context.Background(), nil, "--list-langs")
+	if err != nil {
+		return caps
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "List of") {
+			continue
+		}
+		caps.Languages = append(caps.Languages, line)
+	}
+	return caps
+}
+
+// Close tesseract 不持有常驻进程，无需释放
+func (e *TesseractEngine) Close() error {
+	return nil
+}
+
+// run 运行 tesseract，ctx 结束或超过 Timeout 时结束进程
+func (e *TesseractEngine) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	runCtx := ctx
+	if e.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, e.opts.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(runCtx, e.path, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("tesseract 执行失败: %w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// tesseractWord TSV 中 level=5 的单词记录
+type tesseractWord struct {
+	block, par, line, word int
+	left, top              int
+	width, height          int
+	conf                   float64
+	text                   string
+}
+
+func (w tesseractWord) toData() paddleocr.Data {
+	return paddleocr.Data{
+		Rect:  rectPoints(w.left, w.top, w.left+w.width, w.top+w.height),
+		Score: float32(w.conf / 100),
+		Text:  w.text,
+	}
+}
+
+// parseTesseractTSV 解析 tesseract 的 TSV 输出，只保留有文本的单词
+func parseTesseractTSV(out []byte) ([]tesseractWord, error) {
+	var words []tesseractWord
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 12 || fields[0] != "5" {
+			continue
+		}
+		text := strings.TrimSpace(fields[11])
+		if text == "" {
+			continue
+		}
+		nums := make([]int, 10)
+		for i := 1; i <= 9; i++ {
+			n, err := strconv.Atoi(fields[i])
+			if err != nil {
+				return nil, fmt.Errorf("解析 tesseract 输出失败: %w", err)
+			}
+			nums[i] = n
+		}
+		conf, err := strconv.ParseFloat(fields[10], 64)
+		if err != nil {
+			return nil, fmt.Errorf("解析 tesseract 置信度失败: %w", err)
+		}
+		if conf < 0 {
+			conf = 0
+		}
+		words = append(words, tesseractWord{
+			block: nums[2], par: nums[3], line: nums[4], word: nums[5],
+			left: nums[6], top: nums[7], width: nums[8], height: nums[9],
+			conf: conf, text: text,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取 tesseract 输出失败: %w", err)
+	}
+	return words, nil
+}
+
+// groupTesseractLines 将同一行的单词合并为一个文本框，置信度取平均值
+func groupTesseractLines(words []tesseractWord) []paddleocr.Data {
+	type lineKey struct{ block, par, line int }
+	lines := make(map[lineKey][]tesseractWord)
+	var keys []lineKey
+	for _, w := range words {
+		k := lineKey{w.block, w.par, w.line}
+		if _, ok := lines[k]; !ok {
+			keys = append(keys, k)
+		}
+		lines[k] = append(lines[k], w)
+	}
+
+	data := make([]paddleocr.Data, 0, len(keys))
+	for _, k := range keys {
+		ws := lines[k]
+		sort.SliceStable(ws, func(i, j int) bool { return ws[i].word < ws[j].word })
+		left, top := ws[0].left, ws[0].top
+		right, bottom := ws[0].left+ws[0].width, ws[0].top+ws[0].height
+		var text strings.Builder
+		var conf float64
+		for i, w := range ws {
+			left = min(left, w.left)
+			top = min(top, w.top)
+			right = max(right, w.left+w.width)
+			bottom = max(bottom, w.top+w.height)
+			if i > 0 && needsSpace(ws[i-1].text, w.text) {
+				text.WriteByte(' ')
+			}
+			text.WriteString(w.text)
+			conf += w.conf
+		}
+		data = append(data, paddleocr.Data{
+			Rect:  rectPoints(left, top, right, bottom),
+			Score: float32(conf / float64(len(ws)) / 100),
+			Text:  text.String(),
+		})
+	}
+	return data
+}
+
+// needsSpace 中日韩文字之间不插入空格
+func needsSpace(prev, next string) bool {
+	last := []rune(prev)
+	first := []rune(next)
+	return !(isCJK(last[len(last)-1]) && isCJK(first[0]))
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		unicode.Is(unicode.P, r) && r > unicode.MaxASCII
+}
+
+// rectPoints 生成与 PaddleOCR-json 一致的四点坐标（左上、右上、右下、左下）
+func rectPoints(left, top, right, bottom int) [][]int {
+	return [][]int{{left, top}, {right, top}, {right, bottom}, {left, bottom}}
+}